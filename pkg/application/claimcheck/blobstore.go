@@ -0,0 +1,17 @@
+package claimcheck
+
+import (
+	"context"
+	"errors"
+)
+
+const ReferenceHeader = "claim-check-reference"
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+type BlobStore interface {
+	Put(ctx context.Context, data []byte) (reference string, err error)
+	Get(ctx context.Context, reference string) ([]byte, error)
+	// Delete removes the blob, deleting a missing blob is not an error
+	Delete(ctx context.Context, reference string) error
+}
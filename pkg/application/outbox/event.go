@@ -10,6 +10,12 @@ type EventSerializer[E Event] interface {
 	Serialize(event E) (string, error)
 }
 
+type EventDeserializer[E Event] interface {
+	Deserialize(eventType string, payload string) (E, error)
+}
+
+type EventHandler[E Event] func(ctx context.Context, event E) error
+
 type EventDispatcher[E Event] interface {
 	Dispatch(ctx context.Context, event E) error
 }
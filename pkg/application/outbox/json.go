@@ -0,0 +1,49 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+func NewJSONEventSerializer[E Event]() EventSerializer[E] {
+	return jsonEventSerializer[E]{}
+}
+
+type jsonEventSerializer[E Event] struct{}
+
+func (jsonEventSerializer[E]) Serialize(event E) (string, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func NewJSONEventDeserializer[E Event]() EventDeserializer[E] {
+	return jsonEventDeserializer[E]{}
+}
+
+type jsonEventDeserializer[E Event] struct{}
+
+func (jsonEventDeserializer[E]) Deserialize(_ string, payload string) (E, error) {
+	var event E
+	err := json.Unmarshal([]byte(payload), &event)
+	return event, err
+}
+
+func JSONEventDecoder[E Event, T Event]() EventDecoder[E] {
+	return func(payload string) (E, error) {
+		var zero E
+		var event T
+		err := json.Unmarshal([]byte(payload), &event)
+		if err != nil {
+			return zero, err
+		}
+		decoded, ok := any(event).(E)
+		if !ok {
+			return zero, fmt.Errorf("%T does not implement %s", event, reflect.TypeFor[E]())
+		}
+		return decoded, nil
+	}
+}
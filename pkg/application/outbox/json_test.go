@@ -0,0 +1,47 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type teamCreated struct {
+	ID string `json:"id"`
+}
+
+func (teamCreated) Type() string {
+	return "team_created"
+}
+
+func TestJSONEventSerializer(t *testing.T) {
+	payload, err := NewJSONEventSerializer[userCreated]().Serialize(userCreated{ID: "1"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"1"}`, payload)
+
+	event, err := NewJSONEventDeserializer[userCreated]().Deserialize("user_created", payload)
+	require.NoError(t, err)
+	assert.Equal(t, userCreated{ID: "1"}, event)
+
+	_, err = NewJSONEventDeserializer[userCreated]().Deserialize("user_created", `{`)
+	assert.Error(t, err)
+}
+
+func TestJSONEventDecoder(t *testing.T) {
+	t.Run("decodes concrete type into event interface", func(t *testing.T) {
+		event, err := JSONEventDecoder[Event, userCreated]()(`{"id":"1"}`)
+		require.NoError(t, err)
+		assert.Equal(t, userCreated{ID: "1"}, event)
+	})
+
+	t.Run("rejects type not implementing event interface", func(t *testing.T) {
+		_, err := JSONEventDecoder[userEvent, teamCreated]()(`{"id":"1"}`)
+		assert.ErrorContains(t, err, "does not implement")
+	})
+
+	t.Run("returns unmarshal error", func(t *testing.T) {
+		_, err := JSONEventDecoder[Event, userCreated]()(`{`)
+		assert.Error(t, err)
+	})
+}
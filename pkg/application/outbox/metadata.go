@@ -0,0 +1,37 @@
+package outbox
+
+import (
+	"context"
+	"maps"
+)
+
+type Metadata struct {
+	Headers       map[string]string
+	SchemaVersion uint
+}
+
+type metadataKey struct{}
+
+func WithMetadata(ctx context.Context, metadata Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, metadata)
+}
+
+func WithHeader(ctx context.Context, key, value string) context.Context {
+	metadata := MetadataFromContext(ctx)
+	headers := make(map[string]string, len(metadata.Headers)+1)
+	maps.Copy(headers, metadata.Headers)
+	headers[key] = value
+	metadata.Headers = headers
+	return WithMetadata(ctx, metadata)
+}
+
+func WithSchemaVersion(ctx context.Context, version uint) context.Context {
+	metadata := MetadataFromContext(ctx)
+	metadata.SchemaVersion = version
+	return WithMetadata(ctx, metadata)
+}
+
+func MetadataFromContext(ctx context.Context) Metadata {
+	metadata, _ := ctx.Value(metadataKey{}).(Metadata)
+	return metadata
+}
@@ -0,0 +1,30 @@
+package outbox
+
+import "time"
+
+type RelayMetrics interface {
+	SetBacklog(transportName string, size uint64)
+	SetOldestEventAge(transportName string, age time.Duration)
+	ObserveRelayedEvents(transportName string, count int)
+	IncTransportErrors(transportName string)
+	ObserveLockHoldTime(transportName string, duration time.Duration)
+	IncLockFailures(transportName string)
+}
+
+func NewNopRelayMetrics() RelayMetrics {
+	return nopRelayMetrics{}
+}
+
+type nopRelayMetrics struct{}
+
+func (nopRelayMetrics) SetBacklog(string, uint64) {}
+
+func (nopRelayMetrics) SetOldestEventAge(string, time.Duration) {}
+
+func (nopRelayMetrics) ObserveRelayedEvents(string, int) {}
+
+func (nopRelayMetrics) IncTransportErrors(string) {}
+
+func (nopRelayMetrics) ObserveLockHoldTime(string, time.Duration) {}
+
+func (nopRelayMetrics) IncLockFailures(string) {}
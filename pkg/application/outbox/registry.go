@@ -0,0 +1,45 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrUnknownEventType = errors.New("unknown event type")
+
+type EventDecoder[E Event] func(payload string) (E, error)
+
+type EventRegistry[E Event] interface {
+	EventDeserializer[E]
+	Register(eventType string, decoder EventDecoder[E])
+}
+
+func NewEventRegistry[E Event]() EventRegistry[E] {
+	return &eventRegistry[E]{
+		decoders: make(map[string]EventDecoder[E]),
+	}
+}
+
+type eventRegistry[E Event] struct {
+	mu       sync.RWMutex
+	decoders map[string]EventDecoder[E]
+}
+
+func (r *eventRegistry[E]) Register(eventType string, decoder EventDecoder[E]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.decoders[eventType] = decoder
+}
+
+func (r *eventRegistry[E]) Deserialize(eventType string, payload string) (E, error) {
+	r.mu.RLock()
+	decoder, ok := r.decoders[eventType]
+	r.mu.RUnlock()
+	if !ok {
+		var zero E
+		return zero, fmt.Errorf("%w %q", ErrUnknownEventType, eventType)
+	}
+	return decoder(payload)
+}
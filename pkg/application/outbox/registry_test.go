@@ -0,0 +1,64 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type userEvent interface {
+	Event
+	UserID() string
+}
+
+type userCreated struct {
+	ID string `json:"id"`
+}
+
+func (userCreated) Type() string {
+	return "user_created"
+}
+
+func (e userCreated) UserID() string {
+	return e.ID
+}
+
+type userRemoved struct {
+	ID string `json:"id"`
+}
+
+func (*userRemoved) Type() string {
+	return "user_removed"
+}
+
+func (e *userRemoved) UserID() string {
+	return e.ID
+}
+
+func TestEventRegistry(t *testing.T) {
+	registry := NewEventRegistry[userEvent]()
+	registry.Register(userCreated{}.Type(), JSONEventDecoder[userEvent, userCreated]())
+	registry.Register((*userRemoved)(nil).Type(), JSONEventDecoder[userEvent, *userRemoved]())
+
+	t.Run("decodes registered types", func(t *testing.T) {
+		event, err := registry.Deserialize("user_created", `{"id":"1"}`)
+		require.NoError(t, err)
+		assert.Equal(t, userCreated{ID: "1"}, event)
+
+		event, err = registry.Deserialize("user_removed", `{"id":"2"}`)
+		require.NoError(t, err)
+		assert.Equal(t, &userRemoved{ID: "2"}, event)
+	})
+
+	t.Run("rejects unknown type", func(t *testing.T) {
+		_, err := registry.Deserialize("user_renamed", `{"id":"1"}`)
+		assert.ErrorIs(t, err, ErrUnknownEventType)
+		assert.ErrorContains(t, err, "user_renamed")
+	})
+
+	t.Run("returns decoder error", func(t *testing.T) {
+		_, err := registry.Deserialize("user_created", `{`)
+		assert.Error(t, err)
+	})
+}
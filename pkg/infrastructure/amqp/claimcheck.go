@@ -0,0 +1,51 @@
+package amqp
+
+import (
+	"context"
+	"maps"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/claimcheck"
+)
+
+type ClaimCheckResolverOption func(options *claimCheckResolverOptions)
+
+// WithBlobDeletion deletes the blob once handler succeeds, use it only when a single queue consumes the events,
+// otherwise expire blobs by age, e.g. with FileSystemBlobStore.DeleteExpired
+func WithBlobDeletion() ClaimCheckResolverOption {
+	return func(options *claimCheckResolverOptions) {
+		options.deleteHandled = true
+	}
+}
+
+type claimCheckResolverOptions struct {
+	deleteHandled bool
+}
+
+func NewClaimCheckResolver(store claimcheck.BlobStore, handler Handler, opts ...ClaimCheckResolverOption) Handler {
+	var options claimCheckResolverOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return func(ctx context.Context, delivery Delivery) error {
+		reference, ok := delivery.Headers[claimcheck.ReferenceHeader]
+		if !ok {
+			return handler(ctx, delivery)
+		}
+
+		body, err := store.Get(ctx, reference)
+		if err != nil {
+			return err
+		}
+		delivery.Body = body
+		delivery.Headers = maps.Clone(delivery.Headers)
+		delete(delivery.Headers, claimcheck.ReferenceHeader)
+		err = handler(ctx, delivery)
+		if err != nil || !options.deleteHandled {
+			return err
+		}
+		// failing here would redeliver an event that is already handled, a blob left behind expires by age
+		_ = store.Delete(context.WithoutCancel(ctx), reference)
+		return nil
+	}
+}
@@ -107,6 +107,7 @@ func (c *consumer) consume() error {
 				CorrelationID: delivery.CorrelationId,
 				ContentType:   delivery.ContentType,
 				Type:          delivery.Type,
+				Headers:       deliveryHeaders(delivery.Headers),
 				Body:          delivery.Body,
 			})
 			if err == nil {
@@ -0,0 +1,36 @@
+package amqp
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func publishingHeaders(headers map[string]string) amqp.Table {
+	if len(headers) == 0 {
+		return nil
+	}
+	table := make(amqp.Table, len(headers))
+	for key, value := range headers {
+		table[key] = value
+	}
+	return table
+}
+
+func deliveryHeaders(table amqp.Table) map[string]string {
+	if len(table) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(table))
+	for key, value := range table {
+		switch v := value.(type) {
+		case string:
+			headers[key] = v
+		case []byte:
+			headers[key] = string(v)
+		default:
+			headers[key] = fmt.Sprint(v)
+		}
+	}
+	return headers
+}
@@ -15,6 +15,7 @@ type Delivery struct {
 	CorrelationID string
 	ContentType   string
 	Type          string
+	Headers       map[string]string
 	Body          []byte
 }
 
@@ -124,6 +125,7 @@ func (p *producer) Publish(ctx context.Context, delivery Delivery) error {
 			ContentType:   delivery.ContentType,
 			DeliveryMode:  amqp.Persistent,
 			CorrelationId: delivery.CorrelationID,
+			Headers:       publishingHeaders(delivery.Headers),
 			Timestamp:     time.Now(),
 			Type:          delivery.Type,
 			AppId:         p.appID,
@@ -0,0 +1,21 @@
+package amqp
+
+import (
+	"context"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/outbox"
+)
+
+func NewEventRouter[E outbox.Event](deserializer outbox.EventDeserializer[E], handler outbox.EventHandler[E]) Handler {
+	return func(ctx context.Context, delivery Delivery) error {
+		event, err := deserializer.Deserialize(delivery.Type, string(delivery.Body))
+		if err != nil {
+			return err
+		}
+
+		ctx = outbox.WithMetadata(ctx, outbox.Metadata{
+			Headers: delivery.Headers,
+		})
+		return handler(ctx, event)
+	}
+}
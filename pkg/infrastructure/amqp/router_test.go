@@ -0,0 +1,49 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/outbox"
+)
+
+type testEvent struct {
+	Value string `json:"value"`
+}
+
+func (testEvent) Type() string {
+	return "test"
+}
+
+func TestEventRouter(t *testing.T) {
+	t.Run("passes delivery headers as metadata", func(t *testing.T) {
+		var received testEvent
+		var metadata outbox.Metadata
+		router := NewEventRouter(outbox.NewJSONEventDeserializer[testEvent](), func(ctx context.Context, event testEvent) error {
+			received = event
+			metadata = outbox.MetadataFromContext(ctx)
+			return nil
+		})
+
+		require.NoError(t, router(t.Context(), Delivery{
+			Type:    "test",
+			Headers: map[string]string{"traceparent": "trace"},
+			Body:    []byte(`{"value":"created"}`),
+		}))
+		assert.Equal(t, testEvent{Value: "created"}, received)
+		assert.Equal(t, map[string]string{"traceparent": "trace"}, metadata.Headers)
+	})
+
+	t.Run("rejects unknown event type", func(t *testing.T) {
+		router := NewEventRouter(outbox.NewEventRegistry[testEvent](), func(context.Context, testEvent) error {
+			t.Fatal("handler must not run")
+			return nil
+		})
+
+		err := router(t.Context(), Delivery{Type: "unknown", Body: []byte(`{}`)})
+		assert.ErrorIs(t, err, outbox.ErrUnknownEventType)
+	})
+}
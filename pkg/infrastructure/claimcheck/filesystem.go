@@ -0,0 +1,134 @@
+package claimcheck
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/claimcheck"
+	liberr "gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
+)
+
+var referencePattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+type FileSystemBlobStore interface {
+	claimcheck.BlobStore
+	// DeleteExpired removes blobs stored more than retention ago, retention must cover the time an event
+	// may wait in the outbox and in queues until every consumer has read it
+	DeleteExpired(ctx context.Context, retention time.Duration) (deleted int, err error)
+}
+
+func NewFileSystemBlobStore(dir string) FileSystemBlobStore {
+	return &fileSystemBlobStore{dir: dir}
+}
+
+type fileSystemBlobStore struct {
+	dir string
+}
+
+// Put stores every blob under a new reference, so deleting the blob of one event never affects another
+func (s *fileSystemBlobStore) Put(_ context.Context, data []byte) (reference string, err error) {
+	id := make([]byte, 32)
+	_, err = rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	reference = hex.EncodeToString(id)
+
+	err = os.MkdirAll(s.dir, 0o755)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(s.dir, reference+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			err = liberr.Join(err, os.Remove(file.Name()))
+		}
+	}()
+
+	_, err = file.Write(data)
+	err = liberr.Join(err, file.Close())
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(file.Name(), s.path(reference))
+	if err != nil {
+		return "", err
+	}
+	return reference, nil
+}
+
+func (s *fileSystemBlobStore) Get(_ context.Context, reference string) ([]byte, error) {
+	if !referencePattern.MatchString(reference) {
+		return nil, fmt.Errorf("%w: malformed reference %q", claimcheck.ErrBlobNotFound, reference)
+	}
+	data, err := os.ReadFile(s.path(reference))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", claimcheck.ErrBlobNotFound, reference)
+	}
+	return data, err
+}
+
+func (s *fileSystemBlobStore) Delete(_ context.Context, reference string) error {
+	if !referencePattern.MatchString(reference) {
+		return fmt.Errorf("%w: malformed reference %q", claimcheck.ErrBlobNotFound, reference)
+	}
+	err := os.Remove(s.path(reference))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// DeleteExpired also removes temporary files left by interrupted Put calls
+func (s *fileSystemBlobStore) DeleteExpired(ctx context.Context, retention time.Duration) (deleted int, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	expiredBefore := time.Now().Add(-retention)
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return deleted, ctx.Err()
+		}
+		name := entry.Name()
+		if entry.IsDir() || !referencePattern.MatchString(strings.SplitN(name, ".", 2)[0]) {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		if !info.ModTime().Before(expiredBefore) {
+			continue
+		}
+		err = os.Remove(filepath.Join(s.dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (s *fileSystemBlobStore) path(reference string) string {
+	return filepath.Join(s.dir, reference)
+}
@@ -0,0 +1,47 @@
+package claimcheck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/claimcheck"
+)
+
+func TestFileSystemBlobStoreDeleteExpired(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileSystemBlobStore(dir)
+	expired, err := store.Put(t.Context(), []byte("expired"))
+	require.NoError(t, err)
+	fresh, err := store.Put(t.Context(), []byte("fresh"))
+	require.NoError(t, err)
+
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, expired), old, old))
+	interrupted := filepath.Join(dir, expired[:63]+"0.123.tmp")
+	require.NoError(t, os.WriteFile(interrupted, []byte("partial"), 0o644))
+	require.NoError(t, os.Chtimes(interrupted, old, old))
+	unrelated := filepath.Join(dir, "unrelated")
+	require.NoError(t, os.WriteFile(unrelated, nil, 0o644))
+	require.NoError(t, os.Chtimes(unrelated, old, old))
+
+	deleted, err := store.DeleteExpired(t.Context(), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	_, err = store.Get(t.Context(), expired)
+	assert.ErrorIs(t, err, claimcheck.ErrBlobNotFound)
+	data, err := store.Get(t.Context(), fresh)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("fresh"), data)
+	assert.NoFileExists(t, interrupted)
+	assert.FileExists(t, unrelated)
+
+	deleted, err = NewFileSystemBlobStore(filepath.Join(dir, "missing")).DeleteExpired(t.Context(), time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
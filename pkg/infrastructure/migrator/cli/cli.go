@@ -0,0 +1,328 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/logging"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/migrator"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	liberr "gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
+)
+
+const dsnEnv = "MIGRATOR_DSN"
+
+var ErrUsage = errors.New("invalid usage")
+
+// MigrationSet builds migrations for the connection of the dialect chosen with -dialect
+type MigrationSet func(client mysql.ClientContext, dialect migrator.Dialect) ([]migrator.Migration, error)
+
+// defaultDrivers are database/sql driver names, the binary running Run must import the driver of its dialect
+var defaultDrivers = map[migrator.Dialect]string{
+	migrator.DialectPostgreSQL: "pgx",
+	migrator.DialectSQLite:     "sqlite",
+}
+
+type Config struct {
+	Name        string
+	TablePrefix string
+	Migrations  MigrationSet
+	Logger      logging.Logger
+	Output      io.Writer
+}
+
+func Run(ctx context.Context, config Config, args []string) error {
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+	r := &runner{config: config}
+
+	flags := r.flagSet(config.Name)
+	dsn := flags.String("dsn", os.Getenv(dsnEnv), "database DSN, defaults to $"+dsnEnv)
+	dialect := flags.String("dialect", string(migrator.DialectMySQL), "database dialect: mysql, postgres or sqlite")
+	driver := flags.String("driver", "", "database/sql driver for postgres and sqlite, defaults to pgx and sqlite")
+	outOfOrder := flags.Bool("out-of-order", false, "apply migrations with versions lower than the last applied one")
+	checksumWarn := flags.Bool("checksum-warn", false, "warn instead of failing on checksum mismatch")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(flags.Output(), "usage: %s [flags] up|down|status|plan|force-version|baseline|repair|create [args]\n", config.Name)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return errors.Wrap(ErrUsage, err.Error())
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.Wrap(ErrUsage, "command is required")
+	}
+
+	command, args := flags.Arg(0), flags.Args()[1:]
+	if command == "create" {
+		return r.create(args)
+	}
+
+	conn := connection{dsn: *dsn, dialect: migrator.Dialect(*dialect), driver: *driver}
+	switch conn.dialect {
+	case migrator.DialectMySQL:
+	case migrator.DialectPostgreSQL, migrator.DialectSQLite:
+		if conn.driver == "" {
+			conn.driver = defaultDrivers[conn.dialect]
+		}
+	default:
+		return errors.Wrapf(ErrUsage, "unknown dialect %q", *dialect)
+	}
+
+	opts := []migrator.Option{migrator.WithDialect(conn.dialect)}
+	if *outOfOrder {
+		opts = append(opts, migrator.WithOutOfOrder())
+	}
+	if *checksumWarn {
+		opts = append(opts, migrator.WithChecksumMode(migrator.ChecksumWarn))
+	}
+
+	switch command {
+	case "up":
+		return r.withMigrator(ctx, conn, opts, func(m migrator.Migrator) error {
+			return r.up(m, args)
+		})
+	case "down":
+		return r.withMigrator(ctx, conn, opts, func(m migrator.Migrator) error {
+			return r.down(m, args)
+		})
+	case "status":
+		return r.withMigrator(ctx, conn, opts, r.status)
+	case "plan":
+		return r.withMigrator(ctx, conn, opts, r.plan)
+	case "force-version":
+		return r.withMigrator(ctx, conn, opts, func(m migrator.Migrator) error {
+			return r.forceVersion(m, args)
+		})
+	case "baseline":
+		return r.withMigrator(ctx, conn, opts, func(m migrator.Migrator) error {
+			return r.baseline(m, args)
+		})
+	case "repair":
+		return r.withMigrator(ctx, conn, opts, r.repair)
+	default:
+		flags.Usage()
+		return errors.Wrapf(ErrUsage, "unknown command %q", command)
+	}
+}
+
+type runner struct {
+	config Config
+}
+
+func (r *runner) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(r.config.Output)
+	return flags
+}
+
+type connection struct {
+	dsn     string
+	dialect migrator.Dialect
+	driver  string
+}
+
+// open allows a single connection, advisory locks of PostgreSQL and MySQL belong to the session that took them
+func (c connection) open() (mysql.TransactionalClient, func() error, error) {
+	if c.dialect == migrator.DialectMySQL {
+		connector := mysql.NewConnector()
+		err := connector.Open(c.dsn, mysql.Config{MaxConnections: 1})
+		if err != nil {
+			return nil, nil, err
+		}
+		return connector.TransactionalClient(), connector.Close, nil
+	}
+
+	db, err := sqlx.Open(c.driver, c.dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	db.SetMaxOpenConns(1)
+	err = db.Ping()
+	if err != nil {
+		return nil, nil, liberr.Join(err, db.Close())
+	}
+	return mysql.NewTransactionalClientFromSQLx(db), db.Close, nil
+}
+
+func (r *runner) withMigrator(
+	ctx context.Context,
+	connection connection,
+	opts []migrator.Option,
+	callback func(m migrator.Migrator) error,
+) (err error) {
+	if connection.dsn == "" {
+		return errors.Wrapf(ErrUsage, "dsn is required, pass -dsn or set $%s", dsnEnv)
+	}
+
+	client, closeClient, err := connection.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = liberr.Join(err, closeClient())
+	}()
+	conn, err := client.Connection(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = liberr.Join(err, conn.Close())
+	}()
+
+	migrations, err := r.config.Migrations(conn, connection.dialect)
+	if err != nil {
+		return err
+	}
+	m, err := migrator.NewMigratorFactory(r.config.TablePrefix, conn, r.config.Logger, opts...).NewMigrator(ctx, migrations...)
+	if err != nil {
+		return err
+	}
+	return callback(m)
+}
+
+func (r *runner) up(m migrator.Migrator, args []string) error {
+	flags := r.flagSet("up")
+	dryRun := flags.Bool("dry-run", false, "log pending migrations without applying them")
+	err := flags.Parse(args)
+	if err != nil {
+		return errors.Wrap(ErrUsage, err.Error())
+	}
+	if *dryRun {
+		return m.DryRun()
+	}
+	return m.Migrate()
+}
+
+func (r *runner) down(m migrator.Migrator, args []string) error {
+	flags := r.flagSet("down")
+	to := flags.Int64("to", -1, "version to roll back to, defaults to the one before the last applied")
+	err := flags.Parse(args)
+	if err != nil {
+		return errors.Wrap(ErrUsage, err.Error())
+	}
+	if *to >= 0 {
+		return m.Rollback(*to)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+	var applied []int64
+	for _, status := range statuses {
+		if status.Applied {
+			applied = append(applied, status.Version)
+		}
+	}
+	switch len(applied) {
+	case 0:
+		return nil
+	case 1:
+		return m.Rollback(0)
+	default:
+		return m.Rollback(applied[len(applied)-2])
+	}
+}
+
+func (r *runner) status(m migrator.Migrator) error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(r.config.Output, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tDESCRIPTION")
+	for _, status := range statuses {
+		state := "pending"
+		appliedAt := "-"
+		switch {
+		case status.Dirty:
+			state = "dirty: " + status.Error
+		case status.Unknown:
+			state = "unknown"
+		case status.Applied:
+			state = "applied"
+		}
+		if status.Applied {
+			appliedAt = status.AppliedAt.Format(time.DateTime)
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Version, state, appliedAt, status.Description)
+	}
+	return w.Flush()
+}
+
+func (r *runner) plan(m migrator.Migrator) error {
+	pending, err := m.Plan()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		_, err = fmt.Fprintln(r.config.Output, "no pending migrations")
+		return err
+	}
+	for _, migration := range pending {
+		_, err = fmt.Fprintf(r.config.Output, "%d\t%s\n", migration.Version(), migration.Description())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *runner) forceVersion(m migrator.Migrator, args []string) error {
+	version, err := versionArg("force-version", args)
+	if err != nil {
+		return err
+	}
+	return m.Force(version)
+}
+
+func (r *runner) baseline(m migrator.Migrator, args []string) error {
+	version, err := versionArg("baseline", args)
+	if err != nil {
+		return err
+	}
+	return m.Baseline(version)
+}
+
+func (r *runner) repair(m migrator.Migrator) error {
+	repaired, err := m.Repair()
+	if err != nil {
+		return err
+	}
+	if len(repaired) == 0 {
+		_, err = fmt.Fprintln(r.config.Output, "nothing to repair")
+		return err
+	}
+	for _, version := range repaired {
+		_, err = fmt.Fprintf(r.config.Output, "repaired %d\n", version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func versionArg(command string, args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, errors.Wrapf(ErrUsage, "%s requires a version", command)
+	}
+	version, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(ErrUsage, "invalid version %q", args[0])
+	}
+	return version, nil
+}
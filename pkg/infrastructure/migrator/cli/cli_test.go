@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"bytes"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	texttemplate "text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	// include sqlite driver
+	_ "modernc.org/sqlite"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/logging"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/migrator"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+)
+
+func TestRun(t *testing.T) {
+	t.Run("creates go migration", func(t *testing.T) {
+		dir := t.TempDir()
+		var output bytes.Buffer
+
+		err := Run(t.Context(), Config{Name: "migrate", Output: &output}, []string{"create", "-dir", dir, "-package", "usermigrations", "Create users table"})
+		require.NoError(t, err)
+
+		path := strings.TrimSpace(output.String())
+		assert.Regexp(t, `version\d+\.go$`, path)
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		formatted, err := format.Source(content)
+		require.NoError(t, err)
+		assert.Equal(t, string(formatted), string(content))
+		assert.Contains(t, string(content), "package usermigrations")
+		assert.Contains(t, string(content), `return "Create users table"`)
+	})
+
+	t.Run("creates sql migration", func(t *testing.T) {
+		dir := t.TempDir()
+		var output bytes.Buffer
+
+		err := Run(t.Context(), Config{Name: "migrate", Output: &output}, []string{"create", "-dir", dir, "-sql", "Create users table"})
+		require.NoError(t, err)
+
+		paths := strings.Fields(output.String())
+		require.Len(t, paths, 2)
+		assert.Regexp(t, `\d+_create_users_table\.up\.sql$`, filepath.Base(paths[0]))
+		assert.Regexp(t, `\d+_create_users_table\.down\.sql$`, filepath.Base(paths[1]))
+	})
+
+	t.Run("rejects sql migration description without letters or digits", func(t *testing.T) {
+		dir := t.TempDir()
+		var output bytes.Buffer
+
+		err := Run(t.Context(), Config{Name: "migrate", Output: &output}, []string{"create", "-dir", dir, "-sql", "--", "-!-"})
+		assert.ErrorIs(t, err, ErrUsage)
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("removes partial go migration", func(t *testing.T) {
+		dir := t.TempDir()
+		template := goMigrationTemplate
+		t.Cleanup(func() {
+			goMigrationTemplate = template
+		})
+		goMigrationTemplate = texttemplate.Must(texttemplate.New("migration").Parse("package {{ .Package }}\n{{ .Missing }}"))
+
+		err := Run(t.Context(), Config{Name: "migrate", Output: &bytes.Buffer{}}, []string{"create", "-dir", dir, "Create users table"})
+		assert.Error(t, err)
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("migrates sqlite database", func(t *testing.T) {
+		var output bytes.Buffer
+		config := Config{
+			Name:        "migrate",
+			TablePrefix: "app",
+			Logger:      logging.NewJSONLogger(&logging.Config{AppName: "test"}),
+			Output:      &output,
+			Migrations: func(client mysql.ClientContext, dialect migrator.Dialect) ([]migrator.Migration, error) {
+				return migrator.LoadSQLMigrations(fstest.MapFS{
+					"1_create_user.up.sql":   {Data: []byte("CREATE TABLE user (id INTEGER PRIMARY KEY);")},
+					"1_create_user.down.sql": {Data: []byte("DROP TABLE user;")},
+				}, client, dialect)
+			},
+		}
+		dsn := filepath.Join(t.TempDir(), "app.db")
+		run := func(command ...string) string {
+			t.Helper()
+			output.Reset()
+			require.NoError(t, Run(t.Context(), config, append([]string{"-dialect", "sqlite", "-dsn", dsn}, command...)))
+			return output.String()
+		}
+
+		assert.Regexp(t, `1\s+pending\s+-\s+create user`, run("status"))
+		run("down")
+		assert.Contains(t, run("plan"), "create user")
+		run("up")
+		assert.Regexp(t, `1\s+applied\s+`, run("status"))
+		assert.Contains(t, run("plan"), "no pending migrations")
+		run("down")
+		assert.Regexp(t, `1\s+pending\s+`, run("status"))
+	})
+
+	t.Run("rejects invalid usage", func(t *testing.T) {
+		t.Setenv(dsnEnv, "")
+		var output bytes.Buffer
+		config := Config{Name: "migrate", Output: &output}
+
+		assert.ErrorIs(t, Run(t.Context(), config, nil), ErrUsage)
+		assert.ErrorIs(t, Run(t.Context(), config, []string{"sideways"}), ErrUsage)
+		assert.ErrorIs(t, Run(t.Context(), config, []string{"status"}), ErrUsage)
+		assert.ErrorIs(t, Run(t.Context(), config, []string{"create"}), ErrUsage)
+		assert.ErrorIs(t, Run(t.Context(), config, []string{"-dialect", "oracle", "status"}), ErrUsage)
+	})
+}
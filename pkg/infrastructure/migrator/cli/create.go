@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	liberr "gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
+)
+
+var nonWordPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+var goMigrationTemplate = template.Must(template.New("migration").Parse(`package {{ .Package }}
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/migrator"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+)
+
+func newVersion{{ .Version }}(client mysql.ClientContext) migrator.Migration {
+	return &version{{ .Version }}{
+		client: client,
+	}
+}
+
+type version{{ .Version }} struct {
+	client mysql.ClientContext
+}
+
+func (v version{{ .Version }}) Version() int64 {
+	return {{ .Version }}
+}
+
+func (v version{{ .Version }}) Description() string {
+	return {{ printf "%q" .Description }}
+}
+
+func (v version{{ .Version }}) Up(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, ` + "``" + `)
+	return errors.WithStack(err)
+}
+
+func (v version{{ .Version }}) Down(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, ` + "``" + `)
+	return errors.WithStack(err)
+}
+`))
+
+func (r *runner) create(args []string) error {
+	flags := r.flagSet("create")
+	dir := flags.String("dir", ".", "directory to create the migration in")
+	pkg := flags.String("package", "migrations", "package name of a Go migration")
+	sql := flags.Bool("sql", false, "create up and down SQL files instead of a Go file")
+	err := flags.Parse(args)
+	if err != nil {
+		return errors.Wrap(ErrUsage, err.Error())
+	}
+	description := strings.Join(flags.Args(), " ")
+	if description == "" {
+		return errors.Wrap(ErrUsage, "create requires a description")
+	}
+
+	version := time.Now().Unix()
+	var paths []string
+	if *sql {
+		// the loader only reads files named <version>_<description>
+		slug := strings.Trim(nonWordPattern.ReplaceAllString(strings.ToLower(description), "_"), "_")
+		if slug == "" {
+			return errors.Wrapf(ErrUsage, "description %q has no letters or digits", description)
+		}
+		paths, err = createSQLMigration(*dir, fmt.Sprintf("%d_%s", version, slug))
+	} else {
+		paths, err = createGoMigration(*dir, *pkg, version, description)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		_, err = fmt.Fprintln(r.config.Output, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createGoMigration(dir, pkg string, version int64, description string) (paths []string, err error) {
+	path := filepath.Join(dir, fmt.Sprintf("version%d.go", version))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = errors.WithStack(closeErr)
+		}
+		if err != nil {
+			// a partial file would break the build of the migrations package
+			err = liberr.Join(err, os.Remove(path))
+			paths = nil
+		}
+	}()
+
+	err = goMigrationTemplate.Execute(file, struct {
+		Package     string
+		Version     int64
+		Description string
+	}{
+		Package:     pkg,
+		Version:     version,
+		Description: description,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return []string{path}, nil
+}
+
+func createSQLMigration(dir, name string) (_ []string, err error) {
+	paths := []string{
+		filepath.Join(dir, name+".up.sql"),
+		filepath.Join(dir, name+".down.sql"),
+	}
+	var created []string
+	defer func() {
+		if err != nil {
+			for _, path := range created {
+				err = liberr.Join(err, os.Remove(path))
+			}
+		}
+	}()
+	for _, path := range paths {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		created = append(created, path)
+		err = file.Close()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,68 @@
+package migrator
+
+import (
+	"context"
+	"time"
+)
+
+type DataBatchResult struct {
+	Checkpoint string
+	Processed  int
+	Done       bool
+}
+
+// DataBatch processes the rows after checkpoint, empty on the first call, and returns the checkpoint to continue from
+type DataBatch func(ctx context.Context, checkpoint string) (DataBatchResult, error)
+
+// DataMigration is run batch by batch, its checkpoint is stored after each batch so a failed or interrupted
+// migration resumes from it on the next Migrate instead of being reported as dirty
+type DataMigration interface {
+	TimeoutMigration
+	RunBatch(ctx context.Context, checkpoint string) (DataBatchResult, error)
+}
+
+type DataMigrationConfig struct {
+	Version     int64
+	Description string
+	Batch       DataBatch
+	// BatchTimeout limits each batch instead of the whole migration
+	BatchTimeout *time.Duration
+}
+
+func NewDataMigration(config DataMigrationConfig) DataMigration {
+	return &dataMigration{config: config}
+}
+
+type dataMigration struct {
+	config DataMigrationConfig
+}
+
+func (m *dataMigration) Version() int64 {
+	return m.config.Version
+}
+
+func (m *dataMigration) Description() string {
+	return m.config.Description
+}
+
+func (m *dataMigration) Timeout() time.Duration {
+	if m.config.BatchTimeout == nil {
+		return 0
+	}
+	return *m.config.BatchTimeout
+}
+
+func (m *dataMigration) RunBatch(ctx context.Context, checkpoint string) (DataBatchResult, error) {
+	return m.config.Batch(ctx, checkpoint)
+}
+
+func (m *dataMigration) Up(ctx context.Context) error {
+	var checkpoint string
+	for {
+		result, err := m.RunBatch(ctx, checkpoint)
+		if err != nil || result.Done {
+			return err
+		}
+		checkpoint = result.Checkpoint
+	}
+}
@@ -0,0 +1,109 @@
+package migrator
+
+import (
+	"github.com/jmoiron/sqlx"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/identifier"
+)
+
+type Dialect string
+
+const (
+	DialectMySQL      Dialect = "mysql"
+	DialectPostgreSQL Dialect = "postgres"
+	DialectSQLite     Dialect = "sqlite"
+)
+
+type dialect struct {
+	bindType          int
+	quote             func(name string) string
+	tableExistsQuery  string
+	columnExistsQuery string
+	createTableQuery  string
+	transactionalDDL  bool
+}
+
+var mysqlDialect = dialect{
+	bindType: sqlx.QUESTION,
+	quote:    identifier.QuoteMySQL,
+	tableExistsQuery: `
+		SELECT EXISTS(
+		   SELECT * FROM information_schema.tables
+		   WHERE table_schema = DATABASE()
+		   AND table_name = ?
+		)
+	`,
+	columnExistsQuery: `
+		SELECT EXISTS(
+		   SELECT * FROM information_schema.columns
+		   WHERE table_schema = DATABASE()
+		   AND table_name = ?
+		   AND column_name = ?
+		)
+	`,
+	createTableQuery: `
+		CREATE TABLE %table_name%
+		(
+		    version     BIGINT   NOT NULL,
+		    description TEXT     NOT NULL,
+		    applied_at  DATETIME NOT NULL,
+		    PRIMARY KEY (version)
+		)
+		    ENGINE = InnoDB
+		    CHARACTER SET = utf8mb4
+		    COLLATE utf8mb4_unicode_ci
+	`,
+}
+
+var postgresDialect = dialect{
+	bindType:         sqlx.DOLLAR,
+	quote:            identifier.QuoteANSI,
+	transactionalDDL: true,
+	tableExistsQuery: `
+		SELECT EXISTS(
+		   SELECT * FROM information_schema.tables
+		   WHERE table_schema = current_schema()
+		   AND table_name = ?
+		)
+	`,
+	columnExistsQuery: `
+		SELECT EXISTS(
+		   SELECT * FROM information_schema.columns
+		   WHERE table_schema = current_schema()
+		   AND table_name = ?
+		   AND column_name = ?
+		)
+	`,
+	createTableQuery: `
+		CREATE TABLE %table_name%
+		(
+		    version     BIGINT    NOT NULL,
+		    description TEXT      NOT NULL,
+		    applied_at  TIMESTAMP NOT NULL,
+		    PRIMARY KEY (version)
+		)
+	`,
+}
+
+var sqliteDialect = dialect{
+	bindType:          sqlx.QUESTION,
+	quote:             identifier.QuoteANSI,
+	tableExistsQuery:  `SELECT EXISTS(SELECT * FROM sqlite_master WHERE type = 'table' AND name = ?)`,
+	columnExistsQuery: `SELECT EXISTS(SELECT * FROM pragma_table_info(?) WHERE name = ?)`,
+	transactionalDDL:  true,
+	createTableQuery: `
+		CREATE TABLE %table_name%
+		(
+		    version     INTEGER  NOT NULL,
+		    description TEXT     NOT NULL,
+		    applied_at  DATETIME NOT NULL,
+		    PRIMARY KEY (version)
+		)
+	`,
+}
+
+var dialects = map[Dialect]dialect{
+	DialectMySQL:      mysqlDialect,
+	DialectPostgreSQL: postgresDialect,
+	DialectSQLite:     sqliteDialect,
+}
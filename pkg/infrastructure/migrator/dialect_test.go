@@ -0,0 +1,62 @@
+package migrator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/sqltest"
+)
+
+func TestStorageDialects(t *testing.T) {
+	testCases := []struct {
+		name        string
+		storage     func(client *sqltest.RecordingClient) Storage
+		tableExists string
+		createTable string
+		addColumn   string
+		markDirty   string
+	}{
+		{
+			name:        "mysql",
+			storage:     func(client *sqltest.RecordingClient) Storage { return NewMySQLStorage("test", client) },
+			tableExists: "SELECT EXISTS( SELECT * FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ? )",
+			createTable: "CREATE TABLE `test_migrations` ( version BIGINT NOT NULL, description TEXT NOT NULL, applied_at DATETIME NOT NULL, PRIMARY KEY (version) )" +
+				" ENGINE = InnoDB CHARACTER SET = utf8mb4 COLLATE utf8mb4_unicode_ci",
+			addColumn: "ALTER TABLE `test_migrations` ADD COLUMN dirty BOOLEAN NOT NULL DEFAULT FALSE",
+			markDirty: "UPDATE `test_migrations` SET dirty = TRUE, error_message = ? WHERE version = ?",
+		},
+		{
+			name:        "postgres",
+			storage:     func(client *sqltest.RecordingClient) Storage { return NewPostgreSQLStorage("test", client) },
+			tableExists: "SELECT EXISTS( SELECT * FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1 )",
+			createTable: `CREATE TABLE "test_migrations" ( version BIGINT NOT NULL, description TEXT NOT NULL, applied_at TIMESTAMP NOT NULL, PRIMARY KEY (version) )`,
+			addColumn:   `ALTER TABLE "test_migrations" ADD COLUMN dirty BOOLEAN NOT NULL DEFAULT FALSE`,
+			markDirty:   `UPDATE "test_migrations" SET dirty = TRUE, error_message = $1 WHERE version = $2`,
+		},
+		{
+			name:        "sqlite",
+			storage:     func(client *sqltest.RecordingClient) Storage { return NewSQLiteStorage("test", client) },
+			tableExists: "SELECT EXISTS(SELECT * FROM sqlite_master WHERE type = 'table' AND name = ?)",
+			createTable: `CREATE TABLE "test_migrations" ( version INTEGER NOT NULL, description TEXT NOT NULL, applied_at DATETIME NOT NULL, PRIMARY KEY (version) )`,
+			addColumn:   `ALTER TABLE "test_migrations" ADD COLUMN dirty BOOLEAN NOT NULL DEFAULT FALSE`,
+			markDirty:   `UPDATE "test_migrations" SET dirty = TRUE, error_message = ? WHERE version = ?`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &sqltest.RecordingClient{}
+			storage := tc.storage(client)
+
+			require.NoError(t, storage.Init(t.Context()))
+			require.NoError(t, storage.MarkDirty(t.Context(), 1, nil))
+
+			require.Len(t, client.Queries, 2+2*len(migrationsTableColumns)+1)
+			assert.Equal(t, tc.tableExists, client.Queries[0])
+			assert.Equal(t, tc.createTable, client.Queries[1])
+			assert.Equal(t, tc.addColumn, client.Queries[3])
+			assert.Equal(t, tc.markDirty, client.Queries[len(client.Queries)-1])
+		})
+	}
+}
@@ -5,19 +5,23 @@ import (
 
 	"gitea.xscloud.ru/xscloud/golib/pkg/application/logging"
 	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/identifier"
 
 	"github.com/pkg/errors"
 )
 
+var ErrInvalidName = identifier.ErrInvalid
+
 type Factory interface {
 	NewMigrator(ctx context.Context, migrations ...Migration) (Migrator, error)
 }
 
-func NewMigratorFactory(tablePrefix string, client mysql.ClientContext, logger logging.Logger) Factory {
+func NewMigratorFactory(tablePrefix string, client mysql.ClientContext, logger logging.Logger, opts ...Option) Factory {
 	return &migratorFactory{
 		tablePrefix: tablePrefix,
 		client:      client,
 		logger:      logger,
+		opts:        opts,
 	}
 }
 
@@ -25,18 +29,49 @@ type migratorFactory struct {
 	tablePrefix string
 	client      mysql.ClientContext
 	logger      logging.Logger
+	opts        []Option
 }
 
 func (factory migratorFactory) NewMigrator(ctx context.Context, migrations ...Migration) (Migrator, error) {
 	if len(migrations) == 0 {
 		return nil, errors.New("migrations must not be empty")
 	}
+	err := identifier.Validate(factory.tablePrefix, identifier.MaxLength-len(migrationsTableSuffix))
+	if err != nil {
+		return nil, errors.Wrap(err, "table prefix")
+	}
+	versions := make(map[int64]struct{}, len(migrations))
+	for _, migration := range migrations {
+		if _, ok := versions[migration.Version()]; ok {
+			return nil, errors.Errorf("migration version %v is duplicated", migration.Version())
+		}
+		versions[migration.Version()] = struct{}{}
+	}
+	var (
+		storage Storage
+		locker  Locker
+	)
+	options := newOptions(factory.opts)
+	switch options.dialect {
+	case DialectMySQL:
+		storage = NewMySQLStorage(factory.tablePrefix, factory.client)
+		locker = NewMySQLLocker(factory.client, options.lockName, options.lockTimeout)
+	case DialectPostgreSQL:
+		storage = NewPostgreSQLStorage(factory.tablePrefix, factory.client)
+		locker = NewPostgreSQLLocker(factory.client, options.lockName, options.lockTimeout)
+	case DialectSQLite:
+		storage = NewSQLiteStorage(factory.tablePrefix, factory.client)
+		locker = NewLocalLocker(options.lockName, options.lockTimeout)
+	default:
+		return nil, errors.Errorf("unknown dialect %q", options.dialect)
+	}
 	migrator := NewMigrator(
 		ctx,
-		newStorage(factory.tablePrefix, factory.client),
-		newLocker(factory.client),
+		storage,
+		locker,
 		factory.logger,
 		migrations,
+		factory.opts...,
 	)
 	return migrator, nil
 }
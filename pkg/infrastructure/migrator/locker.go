@@ -2,6 +2,7 @@ package migrator
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
@@ -9,32 +10,129 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	migrationLockName    = "migration"
-	migrationLockTimeout = time.Second * 5
-)
+const advisoryLockRetryInterval = 100 * time.Millisecond
 
-func newLocker(client mysql.ClientContext) *locker {
-	return &locker{
-		client: client,
+func NewMySQLLocker(client mysql.ClientContext, lockName string, lockTimeout time.Duration) Locker {
+	return &mysqlLocker{
+		client:      client,
+		lockName:    lockName,
+		lockTimeout: lockTimeout,
 	}
 }
 
-type locker struct {
-	client mysql.ClientContext
-	lock   mysql.Lock
+type mysqlLocker struct {
+	client      mysql.ClientContext
+	lockName    string
+	lockTimeout time.Duration
+	lock        mysql.Lock
 }
 
-func (m *locker) Lock(ctx context.Context) error {
+func (m *mysqlLocker) Lock(ctx context.Context) error {
 	if m.lock == nil {
-		m.lock = mysql.NewLock(ctx, migrationLockName, migrationLockTimeout, m.client)
+		m.lock = mysql.NewLock(ctx, m.lockName, m.lockTimeout, m.client)
 	}
 	return errors.WithStack(m.lock.Lock())
 }
 
-func (m *locker) Unlock() error {
+func (m *mysqlLocker) Unlock() error {
 	if m.lock == nil {
 		return errors.New("migration locker is nil")
 	}
 	return errors.WithStack(m.lock.Unlock())
 }
+
+// NewPostgreSQLLocker uses a session-level advisory lock, so client must be a single connection
+func NewPostgreSQLLocker(client mysql.ClientContext, lockName string, lockTimeout time.Duration) Locker {
+	return &postgresLocker{
+		client:      client,
+		lockName:    lockName,
+		lockTimeout: lockTimeout,
+	}
+}
+
+type postgresLocker struct {
+	client      mysql.ClientContext
+	lockName    string
+	lockTimeout time.Duration
+	ctx         context.Context
+}
+
+func (m *postgresLocker) Lock(ctx context.Context) error {
+	deadline := time.Now().Add(m.lockTimeout)
+	for {
+		var acquired bool
+		err := m.client.GetContext(ctx, &acquired, "SELECT pg_try_advisory_lock(hashtext($1))", m.lockName)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if acquired {
+			m.ctx = ctx
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.WithStack(mysql.ErrLockTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(advisoryLockRetryInterval):
+		}
+	}
+}
+
+func (m *postgresLocker) Unlock() error {
+	if m.ctx == nil {
+		return errors.WithStack(mysql.ErrLockNotLocked)
+	}
+	var released bool
+	err := m.client.GetContext(m.ctx, &released, "SELECT pg_advisory_unlock(hashtext($1))", m.lockName)
+	m.ctx = nil
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !released {
+		return errors.WithStack(mysql.ErrLockNotLocked)
+	}
+	return nil
+}
+
+var localLocks sync.Map
+
+// NewLocalLocker serializes migrators within the current process only, e.g. for an embedded SQLite database
+func NewLocalLocker(lockName string, lockTimeout time.Duration) Locker {
+	return &localLocker{
+		lockName:    lockName,
+		lockTimeout: lockTimeout,
+	}
+}
+
+type localLocker struct {
+	lockName    string
+	lockTimeout time.Duration
+	locked      bool
+}
+
+func (m *localLocker) Lock(ctx context.Context) error {
+	lock, _ := localLocks.LoadOrStore(m.lockName, make(chan struct{}, 1))
+	timer := time.NewTimer(m.lockTimeout)
+	defer timer.Stop()
+	select {
+	case lock.(chan struct{}) <- struct{}{}:
+		m.locked = true
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return errors.WithStack(mysql.ErrLockTimeout)
+	}
+}
+
+func (m *localLocker) Unlock() error {
+	if !m.locked {
+		return errors.WithStack(mysql.ErrLockNotLocked)
+	}
+	lock, _ := localLocks.Load(m.lockName)
+	<-lock.(chan struct{})
+	m.locked = false
+	return nil
+}
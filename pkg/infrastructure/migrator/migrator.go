@@ -5,10 +5,12 @@ import (
 	"context"
 	"fmt"
 	"slices"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"gitea.xscloud.ru/xscloud/golib/pkg/application/logging"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
 	liberr "gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
 )
 
@@ -18,16 +20,77 @@ type Migration interface {
 	Up(ctx context.Context) error
 }
 
+type ReversibleMigration interface {
+	Migration
+	Down(ctx context.Context) error
+}
+
+type TransactionalMigration interface {
+	Migration
+	UpInTransaction(ctx context.Context, tx mysql.ClientContext) error
+}
+
+type ChecksummedMigration interface {
+	Migration
+	Checksum() string
+}
+
+type TimeoutMigration interface {
+	Migration
+	Timeout() time.Duration
+}
+
+type SQLMigration interface {
+	Migration
+	UpStatements() []string
+}
+
+type MigrationStatus struct {
+	Version     int64
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+	Dirty       bool
+	Error       string
+	Unknown     bool
+}
+
+type DirtyMigration struct {
+	Version int64
+	Error   string
+}
+
+var (
+	ErrIrreversibleMigration = errors.New("migration has no down")
+	ErrDirtyMigration        = errors.New("migration is dirty")
+	ErrChecksumMismatch      = errors.New("migration checksum mismatch")
+)
+
 type Migrator interface {
 	Migrate() error
+	Rollback(toVersion int64) error
+	Force(version int64) error
+	// Baseline marks migrations up to version as applied without running them, to adopt an existing schema
+	Baseline(version int64) error
+	// Repair updates descriptions and checksums of applied migrations to match the code
+	Repair() (repaired []int64, err error)
+	Status() ([]MigrationStatus, error)
+	Plan() ([]Migration, error)
+	DryRun() error
+}
+
+type Locker interface {
+	Lock(ctx context.Context) error
+	Unlock() error
 }
 
 func NewMigrator(
 	ctx context.Context,
-	storage *storage,
-	locker *locker,
+	storage Storage,
+	locker Locker,
 	logger logging.Logger,
 	migrations []Migration,
+	opts ...Option,
 ) Migrator {
 	slices.SortFunc(migrations, func(l, r Migration) int {
 		return cmp.Compare(l.Version(), r.Version())
@@ -38,20 +101,252 @@ func NewMigrator(
 		locker:     locker,
 		logger:     logger,
 		migrations: migrations,
+		options:    newOptions(opts),
 	}
 }
 
 type migrator struct {
 	ctx context.Context
 
-	storage *storage
-	locker  *locker
+	storage Storage
+	locker  Locker
 	logger  logging.Logger
 
 	migrations []Migration
+	options    options
+}
+
+func (m migrator) Migrate() error {
+	return m.execute(func() error {
+		pending, err := m.pending()
+		if err != nil {
+			return err
+		}
+		for _, migration := range pending {
+			err = m.apply(migration)
+			if err != nil {
+				return err
+			}
+			m.logger.Info(fmt.Sprintf("migration '%v' successfully applied", migration.Version()))
+		}
+		return nil
+	})
 }
 
-func (m migrator) Migrate() (err error) {
+// Status, Plan and DryRun only read, they run without the lock and never create the migrations table,
+// without the table every migration is pending
+func (m migrator) Status() ([]MigrationStatus, error) {
+	initialized, err := m.storage.Initialized(m.ctx)
+	if err != nil {
+		return nil, err
+	}
+	var applied []AppliedMigration
+	if initialized {
+		applied, err = m.storage.AppliedMigrations(m.ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	appliedByVersion := make(map[int64]AppliedMigration, len(applied))
+	for _, migration := range applied {
+		appliedByVersion[migration.Version] = migration
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{
+			Version:     migration.Version(),
+			Description: migration.Description(),
+		}
+		if record, ok := appliedByVersion[migration.Version()]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+			status.Dirty = record.Dirty
+			status.Error = record.Error.String
+			delete(appliedByVersion, migration.Version())
+		}
+		statuses = append(statuses, status)
+	}
+	for _, record := range applied {
+		if _, ok := appliedByVersion[record.Version]; !ok {
+			continue
+		}
+		statuses = append(statuses, MigrationStatus{
+			Version:     record.Version,
+			Description: record.Description,
+			Applied:     true,
+			AppliedAt:   record.AppliedAt,
+			Dirty:       record.Dirty,
+			Error:       record.Error.String,
+			Unknown:     true,
+		})
+	}
+	slices.SortFunc(statuses, func(l, r MigrationStatus) int {
+		return cmp.Compare(l.Version, r.Version)
+	})
+	return statuses, nil
+}
+
+func (m migrator) Plan() ([]Migration, error) {
+	initialized, err := m.storage.Initialized(m.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !initialized {
+		return slices.Clone(m.migrations), nil
+	}
+	return m.pending()
+}
+
+func (m migrator) DryRun() error {
+	pending, err := m.Plan()
+	if err != nil {
+		return err
+	}
+	for _, migration := range pending {
+		logger := m.logger.WithField("migration", migration.Version())
+		sqlMigration, ok := migration.(SQLMigration)
+		if !ok {
+			logger.Info(fmt.Sprintf("dry run: would apply '%s'", migration.Description()))
+			continue
+		}
+		for _, statement := range sqlMigration.UpStatements() {
+			logger.Info(fmt.Sprintf("dry run: %s", statement))
+		}
+	}
+	return nil
+}
+
+func (m migrator) Rollback(toVersion int64) error {
+	return m.execute(func() error {
+		err := m.checkDirty(false)
+		if err != nil {
+			return err
+		}
+		lastVersion, err := m.storage.LastVersion(m.ctx)
+		if err != nil {
+			return err
+		}
+		if len(m.migrations) > 0 && lastVersion > m.migrations[len(m.migrations)-1].Version() {
+			return errors.Errorf("applied migration %v is unknown, cannot roll back", lastVersion)
+		}
+
+		var rollback []ReversibleMigration
+		for _, migration := range slices.Backward(m.migrations) {
+			if migration.Version() <= toVersion {
+				break
+			}
+			var applied bool
+			applied, err = m.storage.Applied(m.ctx, migration.Version())
+			if err != nil {
+				return err
+			}
+			if !applied {
+				continue
+			}
+			reversible, ok := migration.(ReversibleMigration)
+			if !ok {
+				return errors.Wrapf(ErrIrreversibleMigration, "migration %v", migration.Version())
+			}
+			rollback = append(rollback, reversible)
+		}
+
+		for _, migration := range rollback {
+			err = m.revert(migration)
+			if err != nil {
+				return err
+			}
+			m.logger.Info(fmt.Sprintf("migration '%v' successfully rolled back", migration.Version()))
+		}
+		return nil
+	})
+}
+
+func (m migrator) Force(version int64) error {
+	return m.execute(func() error {
+		err := m.storage.RemoveDirty(m.ctx)
+		if err != nil {
+			return err
+		}
+		if version == 0 {
+			return nil
+		}
+
+		index := slices.IndexFunc(m.migrations, func(migration Migration) bool {
+			return migration.Version() == version
+		})
+		if index < 0 {
+			return errors.Errorf("migration %v is unknown", version)
+		}
+		applied, err := m.storage.Applied(m.ctx, version)
+		if err != nil || applied {
+			return err
+		}
+		m.logger.Info(fmt.Sprintf("migration '%v' forced as applied", version))
+		return m.storage.Store(m.ctx, m.migrations[index])
+	})
+}
+
+func (m migrator) Baseline(version int64) error {
+	return m.execute(func() error {
+		err := m.checkDirty(false)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if migration.Version() > version {
+				break
+			}
+			var applied bool
+			applied, err = m.storage.Applied(m.ctx, migration.Version())
+			if err != nil {
+				return err
+			}
+			if applied {
+				continue
+			}
+			err = m.storage.Store(m.ctx, migration)
+			if err != nil {
+				return err
+			}
+			m.logger.Info(fmt.Sprintf("migration '%v' baselined as applied", migration.Version()))
+		}
+		return nil
+	})
+}
+
+func (m migrator) Repair() (repaired []int64, err error) {
+	err = m.execute(func() error {
+		records, err := m.storage.AppliedMigrations(m.ctx)
+		if err != nil {
+			return err
+		}
+		migrations := make(map[int64]Migration, len(m.migrations))
+		for _, migration := range m.migrations {
+			migrations[migration.Version()] = migration
+		}
+
+		for _, record := range records {
+			migration, ok := migrations[record.Version]
+			if !ok || record.Dirty {
+				continue
+			}
+			if record.Description == migration.Description() && record.Checksum == checksum(migration) {
+				continue
+			}
+			err = m.storage.UpdateMetadata(m.ctx, migration)
+			if err != nil {
+				return err
+			}
+			m.logger.Info(fmt.Sprintf("migration '%v' repaired", migration.Version()))
+			repaired = append(repaired, migration.Version())
+		}
+		return nil
+	})
+	return repaired, err
+}
+
+func (m migrator) execute(callback func() error) (err error) {
 	err = m.locker.Lock(m.ctx)
 	if err != nil {
 		return err
@@ -67,33 +362,214 @@ func (m migrator) Migrate() (err error) {
 	if err != nil {
 		return err
 	}
+	return callback()
+}
+
+func (m migrator) pending() ([]Migration, error) {
+	err := m.checkDirty(true)
+	if err != nil {
+		return nil, err
+	}
 	lastVersion, err := m.storage.LastVersion(m.ctx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	records, err := m.storage.AppliedMigrations(m.ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]AppliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
 	}
 
+	var pending []Migration
 	for _, migration := range m.migrations {
-		var applied bool
-		applied, err = m.storage.Applied(m.ctx, migration.Version())
-		if err != nil {
-			return err
-		}
-		if applied {
+		if record, ok := applied[migration.Version()]; ok {
+			if record.Dirty {
+				pending = append(pending, migration)
+				continue
+			}
+			err = m.verifyChecksum(migration, record)
+			if err != nil {
+				return nil, err
+			}
 			m.logger.Info(fmt.Sprintf("migration '%v' already applied", migration.Version()))
 			continue
 		}
 		if migration.Version() < lastVersion {
-			return errors.Errorf("migration version %v less then last applied %v", migration.Version(), lastVersion)
+			err = errors.Errorf("migration version %v less then last applied %v", migration.Version(), lastVersion)
+			if !m.options.outOfOrder {
+				return nil, err
+			}
+			m.logger.Warning(err, "applying migration out of order")
+		}
+		pending = append(pending, migration)
+	}
+	return pending, nil
+}
+
+func (m migrator) verifyChecksum(migration Migration, record AppliedMigration) error {
+	expected := checksum(migration)
+	if !expected.Valid || !record.Checksum.Valid || expected.String == record.Checksum.String {
+		return nil
+	}
+
+	err := errors.Wrapf(
+		ErrChecksumMismatch,
+		"migration %v was applied with checksum %s, code has %s",
+		migration.Version(), record.Checksum.String, expected.String,
+	)
+	if m.options.checksumMode == ChecksumWarn {
+		m.logger.Warning(err)
+		return nil
+	}
+	return err
+}
+
+func (m migrator) checkDirty(allowResume bool) error {
+	dirty, found, err := m.storage.Dirty(m.ctx)
+	if err != nil || !found {
+		return err
+	}
+	if allowResume && m.resumable(dirty.Version) {
+		return nil
+	}
+	return errors.Wrapf(ErrDirtyMigration, "migration %v failed with %q, resolve it and force the version", dirty.Version, dirty.Error)
+}
+
+func (m migrator) resumable(version int64) bool {
+	return slices.ContainsFunc(m.migrations, func(migration Migration) bool {
+		_, ok := migration.(DataMigration)
+		return ok && migration.Version() == version
+	})
+}
+
+func (m migrator) withTimeout(migration Migration) (context.Context, context.CancelFunc) {
+	timeout := m.options.migrationTimeout
+	if timed, ok := migration.(TimeoutMigration); ok && timed.Timeout() > 0 {
+		timeout = timed.Timeout()
+	}
+	if timeout <= 0 {
+		return context.WithCancel(m.ctx)
+	}
+	return context.WithTimeout(m.ctx, timeout)
+}
+
+func (m migrator) apply(migration Migration) error {
+	if data, ok := migration.(DataMigration); ok {
+		return m.applyData(data)
+	}
+	if transactional, ok := migration.(TransactionalMigration); ok {
+		tx, err := m.storage.BeginTransaction(m.ctx)
+		if err == nil {
+			return m.applyInTransaction(tx, migration, func(ctx context.Context) error {
+				return transactional.UpInTransaction(ctx, tx)
+			})
+		}
+		if !errors.Is(err, errTransactionsNotSupported) {
+			return err
+		}
+	}
+	if m.storage.TransactionalDDL() {
+		tx, err := m.storage.BeginTransaction(m.ctx)
+		if err != nil {
+			return err
 		}
-		err = migration.Up(m.ctx)
+		return m.applyInTransaction(tx, migration, migration.Up)
+	}
+
+	err := m.storage.StoreDirty(m.ctx, migration)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := m.withTimeout(migration)
+	defer cancel()
+	err = migration.Up(ctx)
+	if err != nil {
+		return liberr.Join(err, m.storage.MarkDirty(m.ctx, migration.Version(), err))
+	}
+	return m.storage.MarkClean(m.ctx, migration.Version())
+}
+
+func (m migrator) applyInTransaction(tx mysql.Transaction, migration Migration, up func(ctx context.Context) error) (err error) {
+	defer func() {
+		if err != nil {
+			err = liberr.Join(err, tx.Rollback())
+		}
+	}()
+
+	ctx, cancel := m.withTimeout(migration)
+	defer cancel()
+	err = up(ctx)
+	if err != nil {
+		return err
+	}
+	err = m.storage.WithClient(tx).Store(m.ctx, migration)
+	if err != nil {
+		return err
+	}
+	return errors.WithStack(tx.Commit())
+}
+
+func (m migrator) revert(migration ReversibleMigration) error {
+	err := m.storage.MarkDirty(m.ctx, migration.Version(), nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := m.withTimeout(migration)
+	defer cancel()
+	err = migration.Down(ctx)
+	if err != nil {
+		return liberr.Join(err, m.storage.MarkDirty(m.ctx, migration.Version(), err))
+	}
+	return m.storage.Remove(m.ctx, migration.Version())
+}
+
+func (m migrator) applyData(migration DataMigration) error {
+	applied, err := m.storage.Applied(m.ctx, migration.Version())
+	if err != nil {
+		return err
+	}
+	if !applied {
+		err = m.storage.StoreDirty(m.ctx, migration)
 		if err != nil {
 			return err
 		}
-		m.logger.Info(fmt.Sprintf("migration '%v' successfully applied", migration.Version()))
-		err = m.storage.Store(m.ctx, migration)
+	}
+	checkpoint, err := m.storage.Checkpoint(m.ctx, migration.Version())
+	if err != nil {
+		return err
+	}
+
+	logger := m.logger.WithField("migration", migration.Version())
+	if checkpoint != "" {
+		logger.Info(fmt.Sprintf("resuming data migration from checkpoint %q", checkpoint))
+	}
+	var processed int
+	for {
+		var result DataBatchResult
+		result, err = m.runBatch(migration, checkpoint)
+		if err != nil {
+			return liberr.Join(err, m.storage.MarkDirty(m.ctx, migration.Version(), err))
+		}
+		processed += result.Processed
+		if result.Done {
+			break
+		}
+		checkpoint = result.Checkpoint
+		err = m.storage.SaveCheckpoint(m.ctx, migration.Version(), checkpoint)
 		if err != nil {
 			return err
 		}
+		logger.Info(fmt.Sprintf("data migration processed %v rows, checkpoint %q", processed, checkpoint))
 	}
-	return err
+	logger.Info(fmt.Sprintf("data migration finished, %v rows processed", processed))
+	return m.storage.MarkClean(m.ctx, migration.Version())
+}
+
+func (m migrator) runBatch(migration DataMigration, checkpoint string) (DataBatchResult, error) {
+	ctx, cancel := m.withTimeout(migration)
+	defer cancel()
+	return migration.RunBatch(ctx, checkpoint)
 }
@@ -0,0 +1,365 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/logging"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+)
+
+type fakeStorage struct {
+	records     map[int64]AppliedMigration
+	checkpoints map[int64]string
+}
+
+func newFakeStorage(versions ...int64) *fakeStorage {
+	s := &fakeStorage{records: make(map[int64]AppliedMigration), checkpoints: make(map[int64]string)}
+	for _, version := range versions {
+		s.records[version] = AppliedMigration{Version: version, AppliedAt: time.Now()}
+	}
+	return s
+}
+
+func (s *fakeStorage) Init(context.Context) error {
+	return nil
+}
+
+func (s *fakeStorage) Initialized(context.Context) (bool, error) {
+	return true, nil
+}
+
+func (s *fakeStorage) LastVersion(context.Context) (int64, error) {
+	var last int64
+	for version := range s.records {
+		last = max(last, version)
+	}
+	return last, nil
+}
+
+func (s *fakeStorage) AppliedMigrations(context.Context) ([]AppliedMigration, error) {
+	versions := slices.Sorted(maps.Keys(s.records))
+	records := make([]AppliedMigration, 0, len(versions))
+	for _, version := range versions {
+		records = append(records, s.records[version])
+	}
+	return records, nil
+}
+
+func (s *fakeStorage) Applied(_ context.Context, version int64) (bool, error) {
+	_, ok := s.records[version]
+	return ok, nil
+}
+
+func (s *fakeStorage) Store(_ context.Context, migration Migration) error {
+	s.records[migration.Version()] = AppliedMigration{
+		Version:     migration.Version(),
+		Description: migration.Description(),
+		AppliedAt:   time.Now(),
+		Checksum:    checksum(migration),
+	}
+	return nil
+}
+
+func (s *fakeStorage) StoreDirty(ctx context.Context, migration Migration) error {
+	err := s.Store(ctx, migration)
+	record := s.records[migration.Version()]
+	record.Dirty = true
+	s.records[migration.Version()] = record
+	return err
+}
+
+func (s *fakeStorage) MarkDirty(_ context.Context, version int64, cause error) error {
+	record := s.records[version]
+	record.Dirty = true
+	if cause != nil {
+		record.Error.String, record.Error.Valid = cause.Error(), true
+	}
+	s.records[version] = record
+	return nil
+}
+
+func (s *fakeStorage) MarkClean(_ context.Context, version int64) error {
+	record := s.records[version]
+	record.Dirty = false
+	record.Error.Valid = false
+	s.records[version] = record
+	delete(s.checkpoints, version)
+	return nil
+}
+
+func (s *fakeStorage) Dirty(context.Context) (DirtyMigration, bool, error) {
+	for _, version := range slices.Sorted(maps.Keys(s.records)) {
+		if record := s.records[version]; record.Dirty {
+			return DirtyMigration{Version: version, Error: record.Error.String}, true, nil
+		}
+	}
+	return DirtyMigration{}, false, nil
+}
+
+func (s *fakeStorage) RemoveDirty(context.Context) error {
+	maps.DeleteFunc(s.records, func(_ int64, record AppliedMigration) bool {
+		return record.Dirty
+	})
+	return nil
+}
+
+func (s *fakeStorage) Remove(_ context.Context, version int64) error {
+	delete(s.records, version)
+	return nil
+}
+
+func (s *fakeStorage) UpdateMetadata(_ context.Context, migration Migration) error {
+	record := s.records[migration.Version()]
+	record.Description = migration.Description()
+	record.Checksum = checksum(migration)
+	s.records[migration.Version()] = record
+	return nil
+}
+
+func (s *fakeStorage) Checkpoint(_ context.Context, version int64) (string, error) {
+	return s.checkpoints[version], nil
+}
+
+func (s *fakeStorage) SaveCheckpoint(_ context.Context, version int64, checkpoint string) error {
+	s.checkpoints[version] = checkpoint
+	return nil
+}
+
+func (s *fakeStorage) BeginTransaction(context.Context) (mysql.Transaction, error) {
+	return nil, errTransactionsNotSupported
+}
+
+func (s *fakeStorage) TransactionalDDL() bool {
+	return false
+}
+
+func (s *fakeStorage) WithClient(mysql.ClientContext) Storage {
+	return s
+}
+
+type fakeLocker struct {
+	locked bool
+}
+
+func (l *fakeLocker) Lock(context.Context) error {
+	if l.locked {
+		return mysql.ErrLockTimeout
+	}
+	l.locked = true
+	return nil
+}
+
+func (l *fakeLocker) Unlock() error {
+	if !l.locked {
+		return mysql.ErrLockNotLocked
+	}
+	l.locked = false
+	return nil
+}
+
+type testMigration struct {
+	version int64
+	applied *[]int64
+	err     error
+}
+
+func (m testMigration) Version() int64 {
+	return m.version
+}
+
+func (m testMigration) Description() string {
+	return "test migration"
+}
+
+func (m testMigration) Up(context.Context) error {
+	if m.err != nil {
+		return m.err
+	}
+	*m.applied = append(*m.applied, m.version)
+	return nil
+}
+
+type reversibleTestMigration struct {
+	testMigration
+	reverted *[]int64
+}
+
+func (m reversibleTestMigration) Down(context.Context) error {
+	*m.reverted = append(*m.reverted, m.version)
+	return nil
+}
+
+func newTestMigrator(storage *fakeStorage, migrations []Migration, opts ...Option) Migrator {
+	logger := logging.NewJSONLogger(&logging.Config{AppName: "test"})
+	return NewMigrator(context.Background(), storage, &fakeLocker{}, logger, migrations, opts...)
+}
+
+func testMigrations(applied *[]int64, versions ...int64) []Migration {
+	migrations := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		migrations = append(migrations, testMigration{version: version, applied: applied})
+	}
+	return migrations
+}
+
+func TestMigrator(t *testing.T) {
+	t.Run("applies pending migrations in version order", func(t *testing.T) {
+		var applied []int64
+		storage := newFakeStorage(1)
+		m := newTestMigrator(storage, testMigrations(&applied, 3, 1, 2))
+
+		require.NoError(t, m.Migrate())
+
+		assert.Equal(t, []int64{2, 3}, applied)
+		assert.Len(t, storage.records, 3)
+		assert.False(t, storage.records[3].Dirty)
+	})
+
+	t.Run("rejects out of order migration by default", func(t *testing.T) {
+		var applied []int64
+		storage := newFakeStorage(1, 3)
+		m := newTestMigrator(storage, testMigrations(&applied, 1, 2, 3, 4))
+
+		assert.Error(t, m.Migrate())
+		assert.Empty(t, applied)
+		assert.NotContains(t, storage.records, int64(2))
+	})
+
+	t.Run("applies out of order migration when enabled", func(t *testing.T) {
+		var applied []int64
+		storage := newFakeStorage(1, 3)
+		m := newTestMigrator(storage, testMigrations(&applied, 1, 2, 3, 4), WithOutOfOrder())
+
+		plan, err := m.Plan()
+		require.NoError(t, err)
+		assert.Len(t, plan, 2)
+
+		require.NoError(t, m.Migrate())
+		assert.Equal(t, []int64{2, 4}, applied)
+		assert.Len(t, storage.records, 4)
+	})
+
+	t.Run("refuses to continue past dirty migration until forced", func(t *testing.T) {
+		var applied []int64
+		storage := newFakeStorage(1)
+		migrations := append(testMigrations(&applied, 1, 3), testMigration{version: 2, err: errors.New("boom")})
+		m := newTestMigrator(storage, migrations)
+
+		assert.EqualError(t, m.Migrate(), "boom")
+		assert.ErrorIs(t, m.Migrate(), ErrDirtyMigration)
+		assert.Empty(t, applied)
+
+		require.NoError(t, m.Force(2))
+		require.NoError(t, m.Migrate())
+		assert.Equal(t, []int64{3}, applied)
+	})
+
+	t.Run("resumes data migration from checkpoint", func(t *testing.T) {
+		rows := []int{1, 2, 3, 4, 5}
+		var (
+			processed []int
+			fail      = true
+		)
+		migration := NewDataMigration(DataMigrationConfig{
+			Version:     2,
+			Description: "backfill",
+			Batch: func(_ context.Context, checkpoint string) (DataBatchResult, error) {
+				offset, _ := strconv.Atoi(checkpoint)
+				if offset == 4 && fail {
+					fail = false
+					return DataBatchResult{}, errors.New("connection lost")
+				}
+				end := min(offset+2, len(rows))
+				processed = append(processed, rows[offset:end]...)
+				return DataBatchResult{Checkpoint: strconv.Itoa(end), Processed: end - offset, Done: end == len(rows)}, nil
+			},
+		})
+		storage := newFakeStorage(1)
+		m := newTestMigrator(storage, []Migration{migration})
+
+		assert.EqualError(t, m.Migrate(), "connection lost")
+		assert.True(t, storage.records[2].Dirty)
+		assert.Equal(t, "4", storage.checkpoints[2])
+
+		require.NoError(t, m.Migrate())
+		assert.Equal(t, rows, processed)
+		assert.False(t, storage.records[2].Dirty)
+		assert.Empty(t, storage.checkpoints)
+	})
+
+	t.Run("cancels migration after timeout", func(t *testing.T) {
+		storage := newFakeStorage()
+		migration := NewDataMigration(DataMigrationConfig{
+			Version: 1,
+			Batch: func(ctx context.Context, _ string) (DataBatchResult, error) {
+				<-ctx.Done()
+				return DataBatchResult{}, ctx.Err()
+			},
+		})
+		m := newTestMigrator(storage, []Migration{migration}, WithMigrationTimeout(time.Millisecond))
+
+		assert.ErrorIs(t, m.Migrate(), context.DeadlineExceeded)
+		assert.True(t, storage.records[1].Dirty)
+	})
+
+	t.Run("rolls back applied migrations in reverse order", func(t *testing.T) {
+		var applied, reverted []int64
+		storage := newFakeStorage(1, 2, 3)
+		migrations := []Migration{testMigration{version: 1, applied: &applied}}
+		for _, version := range []int64{2, 3, 4} {
+			migrations = append(migrations, reversibleTestMigration{testMigration{version: version, applied: &applied}, &reverted})
+		}
+		m := newTestMigrator(storage, migrations)
+
+		require.NoError(t, m.Rollback(1))
+		assert.Equal(t, []int64{3, 2}, reverted)
+		assert.Equal(t, []int64{1}, slices.Sorted(maps.Keys(storage.records)))
+	})
+
+	t.Run("refuses to roll back migration without down", func(t *testing.T) {
+		var applied, reverted []int64
+		storage := newFakeStorage(1, 2, 3)
+		migrations := []Migration{
+			reversibleTestMigration{testMigration{version: 1, applied: &applied}, &reverted},
+			testMigration{version: 2, applied: &applied},
+			reversibleTestMigration{testMigration{version: 3, applied: &applied}, &reverted},
+		}
+		m := newTestMigrator(storage, migrations)
+
+		assert.ErrorIs(t, m.Rollback(0), ErrIrreversibleMigration)
+		assert.Empty(t, reverted)
+		assert.Len(t, storage.records, 3)
+	})
+
+	t.Run("reads status and plan while another migrator holds the lock", func(t *testing.T) {
+		var applied []int64
+		storage := newFakeStorage(1)
+		locker := &fakeLocker{locked: true}
+		logger := logging.NewJSONLogger(&logging.Config{AppName: "test"})
+		m := NewMigrator(context.Background(), storage, locker, logger, testMigrations(&applied, 1, 2))
+
+		statuses, err := m.Status()
+		require.NoError(t, err)
+		require.Len(t, statuses, 2)
+		assert.True(t, statuses[0].Applied)
+		assert.False(t, statuses[1].Applied)
+
+		plan, err := m.Plan()
+		require.NoError(t, err)
+		require.Len(t, plan, 1)
+		assert.Equal(t, int64(2), plan[0].Version())
+
+		require.NoError(t, m.DryRun())
+		assert.Empty(t, applied)
+		assert.ErrorIs(t, m.Migrate(), mysql.ErrLockTimeout)
+	})
+}
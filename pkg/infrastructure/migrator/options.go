@@ -0,0 +1,76 @@
+package migrator
+
+import "time"
+
+const (
+	defaultLockName    = "migration"
+	defaultLockTimeout = time.Second * 5
+)
+
+type ChecksumMode int
+
+const (
+	ChecksumStrict ChecksumMode = iota
+	ChecksumWarn
+)
+
+type Option func(options *options)
+
+func WithChecksumMode(mode ChecksumMode) Option {
+	return func(options *options) {
+		options.checksumMode = mode
+	}
+}
+
+func WithOutOfOrder() Option {
+	return func(options *options) {
+		options.outOfOrder = true
+	}
+}
+
+func WithDialect(dialect Dialect) Option {
+	return func(options *options) {
+		options.dialect = dialect
+	}
+}
+
+func WithLockName(lockName string) Option {
+	return func(options *options) {
+		options.lockName = lockName
+	}
+}
+
+func WithLockTimeout(lockTimeout time.Duration) Option {
+	return func(options *options) {
+		options.lockTimeout = lockTimeout
+	}
+}
+
+// WithMigrationTimeout limits each migration, or each batch of a DataMigration, that sets no timeout of its own
+func WithMigrationTimeout(timeout time.Duration) Option {
+	return func(options *options) {
+		options.migrationTimeout = timeout
+	}
+}
+
+type options struct {
+	checksumMode     ChecksumMode
+	outOfOrder       bool
+	dialect          Dialect
+	lockName         string
+	lockTimeout      time.Duration
+	migrationTimeout time.Duration
+}
+
+func newOptions(opts []Option) options {
+	result := options{
+		checksumMode: ChecksumStrict,
+		dialect:      DialectMySQL,
+		lockName:     defaultLockName,
+		lockTimeout:  defaultLockTimeout,
+	}
+	for _, opt := range opts {
+		opt(&result)
+	}
+	return result
+}
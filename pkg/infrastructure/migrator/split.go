@@ -0,0 +1,194 @@
+package migrator
+
+import (
+	"bufio"
+	"strings"
+)
+
+const defaultDelimiter = ";"
+
+// splitStatements applies the quoting and comment rules of dialect,
+// DELIMITER directives, # comments, /*! hints and backslash escapes only exist in MySQL, dollar quotes only in PostgreSQL
+func splitStatements(script string, dialect Dialect) []string {
+	var (
+		statements []string
+		current    strings.Builder
+	)
+	mysql := dialect == DialectMySQL
+	postgres := dialect == DialectPostgreSQL
+	delimiter := defaultDelimiter
+	flush := func() {
+		statement := strings.TrimSpace(current.String())
+		if statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	s := scanner{script: script}
+	for !s.done() {
+		if mysql && strings.TrimSpace(current.String()) == "" && s.atLineStart() {
+			if newDelimiter, ok := s.delimiterDirective(); ok {
+				flush()
+				delimiter = newDelimiter
+				continue
+			}
+		}
+
+		switch {
+		case s.hasPrefix(delimiter):
+			s.skip(len(delimiter))
+			flush()
+		case s.hasPrefix("--") || (mysql && s.hasPrefix("#")):
+			s.skipLine()
+		case (mysql && s.hasPrefix("/*!")) || s.hasPrefix("/*+"):
+			current.WriteString(s.blockComment())
+		case s.hasPrefix("/*"):
+			s.blockComment()
+		case postgres && s.escapeString():
+			current.WriteByte(s.next())
+			current.WriteString(s.quoted('\'', true))
+		case s.peek() == '\'' || s.peek() == '"' || (s.peek() == '`' && !postgres):
+			current.WriteString(s.quoted(s.peek(), mysql && s.peek() != '`'))
+		case postgres && s.peek() == '$':
+			current.WriteString(s.dollarQuoted())
+		default:
+			current.WriteByte(s.next())
+		}
+	}
+	flush()
+	return statements
+}
+
+type scanner struct {
+	script string
+	pos    int
+}
+
+func (s *scanner) done() bool {
+	return s.pos >= len(s.script)
+}
+
+func (s *scanner) peek() byte {
+	return s.script[s.pos]
+}
+
+func (s *scanner) next() byte {
+	c := s.script[s.pos]
+	s.pos++
+	return c
+}
+
+func (s *scanner) skip(n int) {
+	s.pos += n
+}
+
+func (s *scanner) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(s.script[s.pos:], prefix)
+}
+
+func (s *scanner) atLineStart() bool {
+	for i := s.pos - 1; i >= 0; i-- {
+		switch s.script[i] {
+		case '\n':
+			return true
+		case ' ', '\t', '\r':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (s *scanner) line() string {
+	end := strings.IndexByte(s.script[s.pos:], '\n')
+	if end < 0 {
+		return s.script[s.pos:]
+	}
+	return s.script[s.pos : s.pos+end]
+}
+
+func (s *scanner) skipLine() {
+	s.pos += len(s.line())
+}
+
+func (s *scanner) delimiterDirective() (string, bool) {
+	line := s.line()
+	words := bufio.NewScanner(strings.NewReader(line))
+	words.Split(bufio.ScanWords)
+	if !words.Scan() || !strings.EqualFold(words.Text(), "DELIMITER") || !words.Scan() {
+		return "", false
+	}
+	delimiter := words.Text()
+	s.skipLine()
+	return delimiter, true
+}
+
+func (s *scanner) blockComment() string {
+	start := s.pos
+	end := strings.Index(s.script[s.pos+2:], "*/")
+	if end < 0 {
+		s.pos = len(s.script)
+	} else {
+		s.pos += end + 4
+	}
+	return s.script[start:s.pos]
+}
+
+func (s *scanner) quoted(quote byte, backslashEscapes bool) string {
+	start := s.pos
+	s.pos++
+	for !s.done() {
+		c := s.next()
+		switch {
+		case c == '\\' && backslashEscapes && !s.done():
+			s.pos++
+		case c == quote && !s.done() && s.peek() == quote:
+			s.pos++
+		case c == quote:
+			return s.script[start:s.pos]
+		}
+	}
+	return s.script[start:s.pos]
+}
+
+// escapeString reports whether an E” string starts here, the E must not end a longer identifier
+func (s *scanner) escapeString() bool {
+	if !s.hasPrefix("E'") && !s.hasPrefix("e'") {
+		return false
+	}
+	return s.pos == 0 || !isIdentifierByte(s.script[s.pos-1])
+}
+
+func (s *scanner) dollarQuoted() string {
+	start := s.pos
+	end := strings.IndexByte(s.script[s.pos+1:], '$')
+	if end < 0 || !isDollarTag(s.script[s.pos+1:s.pos+1+end]) {
+		s.pos++
+		return s.script[start:s.pos]
+	}
+	tag := s.script[s.pos : s.pos+end+2]
+	s.pos += len(tag)
+	closing := strings.Index(s.script[s.pos:], tag)
+	if closing < 0 {
+		s.pos = len(s.script)
+		return s.script[start:]
+	}
+	s.pos += closing + len(tag)
+	return s.script[start:s.pos]
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isDollarTag(tag string) bool {
+	for i, c := range tag {
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && (!isDigit || i == 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package migrator
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	for name, tc := range map[string]struct {
+		dialect    Dialect
+		script     string
+		statements []string
+	}{
+		"plain statements": {
+			dialect:    DialectSQLite,
+			script:     "CREATE TABLE a (id INT);\n\nCREATE TABLE b (id INT);\n",
+			statements: []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		"mysql delimiters inside strings and identifiers": {
+			dialect: DialectMySQL,
+			script: `INSERT INTO t VALUES ('a;b', "c;d", 'it''s;', 'back\';slash');` +
+				"\nSELECT `odd;name` FROM t;",
+			statements: []string{
+				`INSERT INTO t VALUES ('a;b', "c;d", 'it''s;', 'back\';slash')`,
+				"SELECT `odd;name` FROM t",
+			},
+		},
+		"mysql comments": {
+			dialect: DialectMySQL,
+			script:  "-- first; comment\nSELECT 1; # second; comment\n/* block;\ncomment */SELECT /*+ hint; */ 2; /*!50100 SELECT 3; */",
+			statements: []string{
+				"SELECT 1",
+				"SELECT /*+ hint; */ 2",
+				"/*!50100 SELECT 3; */",
+			},
+		},
+		"mysql delimiter directive": {
+			dialect: DialectMySQL,
+			script:  "DELIMITER $$\nCREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = 1; END$$\nDELIMITER ;\nSELECT 1;",
+			statements: []string{
+				"CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = 1; END",
+				"SELECT 1",
+			},
+		},
+		"mysql column named delimiter": {
+			dialect:    DialectMySQL,
+			script:     "CREATE TABLE a (\ndelimiter VARCHAR(8)\n);",
+			statements: []string{"CREATE TABLE a (\ndelimiter VARCHAR(8)\n)"},
+		},
+		"postgres dollar quoted body": {
+			dialect: DialectPostgreSQL,
+			script:  "CREATE FUNCTION f() RETURNS INT AS $body$ BEGIN RETURN 1; END $body$ LANGUAGE plpgsql;\nCREATE FUNCTION g() RETURNS INT AS $$ SELECT 1; $$ LANGUAGE sql;\nSELECT $1;",
+			statements: []string{
+				"CREATE FUNCTION f() RETURNS INT AS $body$ BEGIN RETURN 1; END $body$ LANGUAGE plpgsql",
+				"CREATE FUNCTION g() RETURNS INT AS $$ SELECT 1; $$ LANGUAGE sql",
+				"SELECT $1",
+			},
+		},
+		"postgres json path operator": {
+			dialect: DialectPostgreSQL,
+			script:  "SELECT data #> '{a,b}', data #>> '{c}' FROM t;\nSELECT 2;",
+			statements: []string{
+				"SELECT data #> '{a,b}', data #>> '{c}' FROM t",
+				"SELECT 2",
+			},
+		},
+		"postgres backslashes": {
+			dialect: DialectPostgreSQL,
+			script:  `SELECT 'C:\'; SELECT E'it\'s;', e'\\'; SELECT name'x;y' FROM t;`,
+			statements: []string{
+				`SELECT 'C:\'`,
+				`SELECT E'it\'s;', e'\\'`,
+				`SELECT name'x;y' FROM t`,
+			},
+		},
+		"sqlite backslashes and hashes": {
+			dialect: DialectSQLite,
+			script:  `INSERT INTO t VALUES ('C:\'); SELECT "#a;" FROM t;`,
+			statements: []string{
+				`INSERT INTO t VALUES ('C:\')`,
+				`SELECT "#a;" FROM t`,
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.statements, splitStatements(tc.script, tc.dialect))
+		})
+	}
+}
+
+func TestLoadSQLMigrations(t *testing.T) {
+	migrations, err := LoadSQLMigrations(fstest.MapFS{
+		"1700000000_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT);")},
+		"1700000000_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"1700000100_seed_users.up.sql":     {Data: []byte("INSERT INTO users VALUES (1); INSERT INTO users VALUES (2);")},
+		"README.md":                        {Data: []byte("not a migration")},
+	}, nil, DialectMySQL)
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+
+	byVersion := make(map[int64]Migration)
+	for _, migration := range migrations {
+		byVersion[migration.Version()] = migration
+	}
+	assert.Equal(t, "create users", byVersion[1700000000].Description())
+	assert.Implements(t, (*ReversibleMigration)(nil), byVersion[1700000000])
+	assert.NotImplements(t, (*ReversibleMigration)(nil), byVersion[1700000100])
+	assert.NotImplements(t, (*TransactionalMigration)(nil), byVersion[1700000100])
+	sum := sha256.Sum256([]byte("CREATE TABLE users (id INT);"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), byVersion[1700000000].(ChecksummedMigration).Checksum())
+
+	_, err = LoadSQLMigrations(fstest.MapFS{
+		"1700000000_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	}, nil, DialectMySQL)
+	assert.Error(t, err)
+
+	migrations, err = LoadSQLMigrations(fstest.MapFS{
+		"1700000000_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT);")},
+		"1700000000_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"1700000100_seed_users.up.sql":     {Data: []byte("INSERT INTO users VALUES (1);")},
+	}, nil, DialectPostgreSQL)
+	require.NoError(t, err)
+	for _, migration := range migrations {
+		assert.Implements(t, (*TransactionalMigration)(nil), migration)
+	}
+	assert.Implements(t, (*ReversibleMigration)(nil), slices.MinFunc(migrations, func(l, r Migration) int {
+		return cmp.Compare(l.Version(), r.Version())
+	}))
+}
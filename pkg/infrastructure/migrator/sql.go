@@ -0,0 +1,150 @@
+package migrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+)
+
+var sqlMigrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// LoadSQLMigrations runs each file in a transaction when the dialect supports transactional DDL
+func LoadSQLMigrations(fsys fs.FS, client mysql.ClientContext, dialect Dialect) ([]Migration, error) {
+	d, ok := dialects[dialect]
+	if !ok {
+		return nil, errors.Errorf("unknown dialect %q", dialect)
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	scripts := make(map[int64]*sqlMigration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := sqlMigrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		var version int64
+		version, err = strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid migration version in %s", entry.Name())
+		}
+		description := strings.ReplaceAll(match[2], "_", " ")
+		migration, found := scripts[version]
+		if !found {
+			migration = &sqlMigration{version: version, description: description, client: client}
+			scripts[version] = migration
+		}
+		if migration.description != description {
+			return nil, errors.Errorf("migration %v has different descriptions in up and down files", version)
+		}
+
+		var content []byte
+		content, err = fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		statements := splitStatements(string(content), dialect)
+		if match[3] == "up" {
+			sum := sha256.Sum256(content)
+			migration.checksum = hex.EncodeToString(sum[:])
+			migration.up = &statements
+		} else {
+			migration.down = &statements
+		}
+	}
+
+	migrations := make([]Migration, 0, len(scripts))
+	for version, migration := range scripts {
+		if migration.up == nil {
+			return nil, errors.Errorf("migration %v has no up file", version)
+		}
+		switch {
+		case migration.down == nil && d.transactionalDDL:
+			migrations = append(migrations, &transactionalSQLMigration{sqlMigration: migration})
+		case migration.down == nil:
+			migrations = append(migrations, migration)
+		case d.transactionalDDL:
+			migrations = append(migrations, &reversibleTransactionalSQLMigration{reversibleSQLMigration{sqlMigration: migration}})
+		default:
+			migrations = append(migrations, &reversibleSQLMigration{sqlMigration: migration})
+		}
+	}
+	return migrations, nil
+}
+
+type sqlMigration struct {
+	version     int64
+	description string
+	up          *[]string
+	down        *[]string
+	checksum    string
+	client      mysql.ClientContext
+}
+
+func (m *sqlMigration) Version() int64 {
+	return m.version
+}
+
+func (m *sqlMigration) Description() string {
+	return m.description
+}
+
+func (m *sqlMigration) Checksum() string {
+	return m.checksum
+}
+
+func (m *sqlMigration) UpStatements() []string {
+	return *m.up
+}
+
+func (m *sqlMigration) Up(ctx context.Context) error {
+	return execStatements(ctx, m.client, *m.up)
+}
+
+type reversibleSQLMigration struct {
+	*sqlMigration
+}
+
+func (m *reversibleSQLMigration) Down(ctx context.Context) error {
+	return execStatements(ctx, m.client, *m.down)
+}
+
+type transactionalSQLMigration struct {
+	*sqlMigration
+}
+
+func (m *transactionalSQLMigration) UpInTransaction(ctx context.Context, tx mysql.ClientContext) error {
+	return execStatements(ctx, tx, *m.up)
+}
+
+type reversibleTransactionalSQLMigration struct {
+	reversibleSQLMigration
+}
+
+func (m *reversibleTransactionalSQLMigration) UpInTransaction(ctx context.Context, tx mysql.ClientContext) error {
+	return execStatements(ctx, tx, *m.up)
+}
+
+func execStatements(ctx context.Context, client mysql.ClientContext, statements []string) error {
+	for _, statement := range statements {
+		_, err := client.ExecContext(ctx, statement)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package migrator
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	// include sqlite driver
+	_ "modernc.org/sqlite"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/logging"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+)
+
+func newSQLiteMigrator(t *testing.T) (Migrator, *sqlx.DB) {
+	t.Helper()
+	db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "migrator.db")+"?_pragma=busy_timeout(5000)")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	migrations, err := LoadSQLMigrations(fstest.MapFS{
+		"1_create_user.up.sql":   {Data: []byte("CREATE TABLE user (id INTEGER PRIMARY KEY, name TEXT NOT NULL);")},
+		"1_create_user.down.sql": {Data: []byte("DROP TABLE user;")},
+		"2_add_email.up.sql":     {Data: []byte("ALTER TABLE user ADD COLUMN email TEXT;")},
+		"2_add_email.down.sql":   {Data: []byte("ALTER TABLE user DROP COLUMN email;")},
+	}, db, DialectSQLite)
+	require.NoError(t, err)
+
+	logger := logging.NewJSONLogger(&logging.Config{AppName: "test"})
+	m, err := NewMigratorFactory("app", db, logger, WithDialect(DialectSQLite)).NewMigrator(context.Background(), migrations...)
+	require.NoError(t, err)
+	return m, db
+}
+
+func TestSQLiteMigrator(t *testing.T) {
+	m, db := newSQLiteMigrator(t)
+
+	require.NoError(t, m.Migrate())
+	_, err := db.Exec("INSERT INTO user (name, email) VALUES ('alice', 'alice@example.com')")
+	require.NoError(t, err)
+
+	statuses, err := m.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	for _, status := range statuses {
+		assert.True(t, status.Applied)
+		assert.False(t, status.Dirty)
+		assert.False(t, status.AppliedAt.IsZero())
+	}
+
+	require.NoError(t, m.Rollback(1))
+	statuses, err = m.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[0].Applied)
+	assert.False(t, statuses[1].Applied)
+
+	_, err = db.Exec("INSERT INTO user (name, email) VALUES ('bob', 'bob@example.com')")
+	assert.Error(t, err)
+}
+
+func TestSQLiteMigratorBaseline(t *testing.T) {
+	m, db := newSQLiteMigrator(t)
+	_, err := db.Exec("CREATE TABLE user (id INTEGER PRIMARY KEY, name TEXT NOT NULL)")
+	require.NoError(t, err)
+
+	require.NoError(t, m.Baseline(1))
+	require.NoError(t, m.Migrate())
+	_, err = db.Exec("INSERT INTO user (name, email) VALUES ('alice', 'alice@example.com')")
+	require.NoError(t, err)
+
+	_, err = db.Exec(`UPDATE app_migrations SET description = 'old', checksum = 'stale' WHERE version = 1`)
+	require.NoError(t, err)
+	assert.ErrorIs(t, m.Migrate(), ErrChecksumMismatch)
+
+	repaired, err := m.Repair()
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1}, repaired)
+	require.NoError(t, m.Migrate())
+
+	statuses, err := m.Status()
+	require.NoError(t, err)
+	assert.Equal(t, "create user", statuses[0].Description)
+}
+
+func TestSQLiteMigratorReadOnly(t *testing.T) {
+	t.Run("reports every migration pending without creating storage", func(t *testing.T) {
+		m, db := newSQLiteMigrator(t)
+
+		statuses, err := m.Status()
+		require.NoError(t, err)
+		require.Len(t, statuses, 2)
+		for _, status := range statuses {
+			assert.False(t, status.Applied)
+		}
+		plan, err := m.Plan()
+		require.NoError(t, err)
+		assert.Len(t, plan, 2)
+		require.NoError(t, m.DryRun())
+
+		var tables int
+		require.NoError(t, db.Get(&tables, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'"))
+		assert.Zero(t, tables)
+	})
+
+	t.Run("dry run does not execute statements", func(t *testing.T) {
+		m, db := newSQLiteMigrator(t)
+		require.NoError(t, m.Migrate())
+		require.NoError(t, m.Rollback(1))
+
+		require.NoError(t, m.DryRun())
+		_, err := db.Exec("INSERT INTO user (name, email) VALUES ('alice', 'alice@example.com')")
+		assert.Error(t, err)
+	})
+
+	t.Run("reads table created by an older version", func(t *testing.T) {
+		m, db := newSQLiteMigrator(t)
+		_, err := db.Exec("CREATE TABLE app_migrations (version INTEGER NOT NULL PRIMARY KEY, description TEXT NOT NULL, applied_at DATETIME NOT NULL)")
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO app_migrations (version, description, applied_at) VALUES (1, 'create user', CURRENT_TIMESTAMP)")
+		require.NoError(t, err)
+
+		statuses, err := m.Status()
+		require.NoError(t, err)
+		require.Len(t, statuses, 2)
+		assert.True(t, statuses[0].Applied)
+		assert.False(t, statuses[0].Dirty)
+		assert.False(t, statuses[1].Applied)
+		plan, err := m.Plan()
+		require.NoError(t, err)
+		require.Len(t, plan, 1)
+
+		var columns int
+		require.NoError(t, db.Get(&columns, "SELECT COUNT(*) FROM pragma_table_info('app_migrations')"))
+		assert.Equal(t, 3, columns)
+	})
+}
+
+func TestSQLiteSQLMigrationTransaction(t *testing.T) {
+	_, db := newSQLiteMigrator(t)
+	client := mysql.NewTransactionalClientFromSQLx(db)
+	migrations, err := LoadSQLMigrations(fstest.MapFS{
+		"1_create_user.up.sql": {Data: []byte("CREATE TABLE user (id INTEGER PRIMARY KEY);\nINSERT INTO missing (id) VALUES (1);")},
+	}, client, DialectSQLite)
+	require.NoError(t, err)
+	logger := logging.NewJSONLogger(&logging.Config{AppName: "test"})
+	m, err := NewMigratorFactory("app", client, logger, WithDialect(DialectSQLite)).NewMigrator(t.Context(), migrations...)
+	require.NoError(t, err)
+
+	assert.Error(t, m.Migrate())
+
+	var tables int
+	require.NoError(t, db.Get(&tables, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'user'"))
+	assert.Zero(t, tables)
+	statuses, err := m.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	assert.False(t, statuses[0].Applied)
+	assert.False(t, statuses[0].Dirty)
+}
+
+type statementsMigration struct {
+	client     mysql.ClientContext
+	statements []string
+}
+
+func (m statementsMigration) Version() int64 {
+	return 1
+}
+
+func (m statementsMigration) Description() string {
+	return "statements"
+}
+
+func (m statementsMigration) Up(ctx context.Context) error {
+	for _, statement := range m.statements {
+		_, err := m.client.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSQLiteMigratorTransactionalDDL(t *testing.T) {
+	_, db := newSQLiteMigrator(t)
+	conn, err := mysql.NewTransactionalClientFromSQLx(db).Connection(t.Context())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	migration := statementsMigration{client: conn, statements: []string{
+		"CREATE TABLE user (id INTEGER PRIMARY KEY)",
+		"INSERT INTO missing (id) VALUES (1)",
+	}}
+	logger := logging.NewJSONLogger(&logging.Config{AppName: "test"})
+	m, err := NewMigratorFactory("app", conn, logger, WithDialect(DialectSQLite)).NewMigrator(t.Context(), migration)
+	require.NoError(t, err)
+
+	assert.Error(t, m.Migrate())
+
+	var tables int
+	require.NoError(t, conn.GetContext(t.Context(), &tables, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'user'"))
+	assert.Zero(t, tables)
+	statuses, err := m.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	assert.False(t, statuses[0].Applied)
+	assert.False(t, statuses[0].Dirty)
+
+	migration.statements = migration.statements[:1]
+	m, err = NewMigratorFactory("app", conn, logger, WithDialect(DialectSQLite)).NewMigrator(t.Context(), migration)
+	require.NoError(t, err)
+	require.NoError(t, m.Migrate())
+	statuses, err = m.Status()
+	require.NoError(t, err)
+	assert.True(t, statuses[0].Applied)
+}
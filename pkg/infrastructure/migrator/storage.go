@@ -6,66 +6,130 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jmoiron/sqlx"
+
 	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/sqltime"
 
 	"github.com/pkg/errors"
 )
 
-func newStorage(
-	tablePrefix string,
-	client mysql.ClientContext,
-) *storage {
-	return &storage{
-		tablePrefix: tablePrefix,
-		client:      client,
-	}
+const migrationsTableSuffix = "_migrations"
+
+var errTransactionsNotSupported = errors.New("client does not support transactions")
+
+type Storage interface {
+	Init(ctx context.Context) error
+	// Initialized reports whether the migrations table exists, it never creates or upgrades the table
+	Initialized(ctx context.Context) (bool, error)
+	LastVersion(ctx context.Context) (int64, error)
+	AppliedMigrations(ctx context.Context) ([]AppliedMigration, error)
+	Applied(ctx context.Context, version int64) (bool, error)
+	Store(ctx context.Context, migration Migration) error
+	StoreDirty(ctx context.Context, migration Migration) error
+	MarkDirty(ctx context.Context, version int64, cause error) error
+	MarkClean(ctx context.Context, version int64) error
+	Dirty(ctx context.Context) (DirtyMigration, bool, error)
+	RemoveDirty(ctx context.Context) error
+	Remove(ctx context.Context, version int64) error
+	UpdateMetadata(ctx context.Context, migration Migration) error
+	Checkpoint(ctx context.Context, version int64) (string, error)
+	SaveCheckpoint(ctx context.Context, version int64, checkpoint string) error
+	BeginTransaction(ctx context.Context) (mysql.Transaction, error)
+	// TransactionalDDL reports whether schema changes on the storage connection roll back together with its records
+	TransactionalDDL() bool
+	WithClient(client mysql.ClientContext) Storage
+}
+
+func NewMySQLStorage(tablePrefix string, client mysql.ClientContext) Storage {
+	return &storage{tablePrefix: tablePrefix, client: client, dialect: mysqlDialect}
+}
+
+func NewPostgreSQLStorage(tablePrefix string, client mysql.ClientContext) Storage {
+	return &storage{tablePrefix: tablePrefix, client: client, dialect: postgresDialect}
+}
+
+func NewSQLiteStorage(tablePrefix string, client mysql.ClientContext) Storage {
+	return &storage{tablePrefix: tablePrefix, client: client, dialect: sqliteDialect}
 }
 
 type storage struct {
 	tablePrefix string
 	client      mysql.ClientContext
+	dialect     dialect
 }
 
-func (storage *storage) Init(ctx context.Context) (err error) {
-	const checkExistSQLQuery = `
-		SELECT EXISTS(
-    	   SELECT * FROM information_schema.tables 
-    	   WHERE table_schema = DATABASE() 
-    	   AND table_name = ?
-		)
-	`
-	var exists bool
-	err = storage.client.GetContext(ctx, &exists, checkExistSQLQuery, storage.tableName())
+func (storage *storage) Init(ctx context.Context) error {
+	exists, err := storage.Initialized(ctx)
 	if err != nil {
-		err = errors.WithStack(err)
 		return err
 	}
 
 	if exists {
-		return nil
+		return storage.upgrade(ctx)
 	}
 
-	const createMigrationsTableSQLQuery = `
-		CREATE TABLE %table_name%
-		(
-		    version     BIGINT   NOT NULL,
-		    description TEXT     NOT NULL,
-		    applied_at  DATETIME NOT NULL,
-		    PRIMARY KEY (version)
-		) 
-    		ENGINE = InnoDB
-    		CHARACTER SET = utf8mb4
-    		COLLATE utf8mb4_unicode_ci
-	`
-	_, err = storage.client.ExecContext(ctx, prepareQuery(createMigrationsTableSQLQuery, storage.tableName()))
-	err = errors.WithStack(err)
-	return err
+	_, err = storage.client.ExecContext(ctx, storage.query(storage.dialect.createTableQuery))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return storage.upgrade(ctx)
+}
+
+func (storage *storage) Initialized(ctx context.Context) (bool, error) {
+	var exists bool
+	err := storage.client.GetContext(ctx, &exists, storage.rebind(storage.dialect.tableExistsQuery), storage.tableName())
+	return exists, errors.WithStack(err)
+}
+
+var migrationsTableColumns = []struct {
+	name       string
+	definition string
+}{
+	{name: "dirty", definition: "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{name: "error_message", definition: "TEXT NULL"},
+	{name: "checksum", definition: "VARCHAR(64) NULL"},
+	{name: "checkpoint", definition: "TEXT NULL"},
+}
+
+// appliedMigrationColumns are added by upgrade, until then they are read as fallback
+var appliedMigrationColumns = []struct {
+	name     string
+	fallback string
+}{
+	{name: "dirty", fallback: "FALSE"},
+	{name: "error_message", fallback: "NULL"},
+	{name: "checksum", fallback: "NULL"},
+}
+
+func (storage *storage) columnExists(ctx context.Context, column string) (bool, error) {
+	var exists bool
+	err := storage.client.GetContext(ctx, &exists, storage.rebind(storage.dialect.columnExistsQuery), storage.tableName(), column)
+	return exists, errors.WithStack(err)
+}
+
+func (storage *storage) upgrade(ctx context.Context) error {
+	for _, column := range migrationsTableColumns {
+		exists, err := storage.columnExists(ctx, column.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		_, err = storage.client.ExecContext(ctx, storage.query("ALTER TABLE %table_name% ADD COLUMN "+column.name+" "+column.definition))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
 }
 
 func (storage *storage) LastVersion(ctx context.Context) (int64, error) {
 	const lastVersionSQLQuery = `SELECT MAX(version) FROM %table_name%`
 	var version sql.NullInt64
-	err := storage.client.GetContext(ctx, &version, prepareQuery(lastVersionSQLQuery, storage.tableName()))
+	err := storage.client.GetContext(ctx, &version, storage.query(lastVersionSQLQuery))
 	if err != nil {
 		return 0, errors.WithStack(err)
 	}
@@ -75,23 +139,202 @@ func (storage *storage) LastVersion(ctx context.Context) (int64, error) {
 	return version.Int64, nil
 }
 
+type AppliedMigration struct {
+	Version     int64          `db:"version"`
+	Description string         `db:"description"`
+	AppliedAt   time.Time      `db:"applied_at"`
+	Dirty       bool           `db:"dirty"`
+	Error       sql.NullString `db:"error_message"`
+	Checksum    sql.NullString `db:"checksum"`
+}
+
+type appliedMigrationRow struct {
+	Version     int64          `db:"version"`
+	Description string         `db:"description"`
+	AppliedAt   sqltime.Time   `db:"applied_at"`
+	Dirty       bool           `db:"dirty"`
+	Error       sql.NullString `db:"error_message"`
+	Checksum    sql.NullString `db:"checksum"`
+}
+
+// AppliedMigrations also reads tables not upgraded yet, so status can be checked without Init
+func (storage *storage) AppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	columns := []string{"version", "description", "applied_at"}
+	for _, column := range appliedMigrationColumns {
+		exists, err := storage.columnExists(ctx, column.name)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			columns = append(columns, column.name)
+		} else {
+			columns = append(columns, column.fallback+" AS "+column.name)
+		}
+	}
+
+	var rows []appliedMigrationRow
+	err := storage.client.SelectContext(ctx, &rows, storage.query(
+		"SELECT "+strings.Join(columns, ", ")+" FROM %table_name% ORDER BY version",
+	))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	migrations := make([]AppliedMigration, 0, len(rows))
+	for _, row := range rows {
+		migrations = append(migrations, AppliedMigration{
+			Version:     row.Version,
+			Description: row.Description,
+			AppliedAt:   row.AppliedAt.Time,
+			Dirty:       row.Dirty,
+			Error:       row.Error,
+			Checksum:    row.Checksum,
+		})
+	}
+	return migrations, nil
+}
+
 func (storage *storage) Applied(ctx context.Context, version int64) (bool, error) {
 	const appliedSQLQuery = `SELECT EXISTS(SELECT version FROM %table_name% WHERE version = ?)`
 	var applied bool
-	err := storage.client.GetContext(ctx, &applied, prepareQuery(appliedSQLQuery, storage.tableName()), version)
+	err := storage.client.GetContext(ctx, &applied, storage.query(appliedSQLQuery), version)
 	return applied, errors.WithStack(err)
 }
 
 func (storage *storage) Store(ctx context.Context, migration Migration) error {
-	const storeSQLQuery = `INSERT INTO %table_name% (version, description, applied_at) VALUES(?, ?, ?)`
-	_, err := storage.client.ExecContext(ctx, prepareQuery(storeSQLQuery, storage.tableName()), migration.Version(), migration.Description(), time.Now())
+	const storeSQLQuery = `INSERT INTO %table_name% (version, description, applied_at, checksum) VALUES(?, ?, ?, ?)`
+	_, err := storage.client.ExecContext(
+		ctx,
+		storage.query(storeSQLQuery),
+		migration.Version(),
+		migration.Description(),
+		time.Now(),
+		checksum(migration),
+	)
+	return errors.WithStack(err)
+}
+
+func (storage *storage) StoreDirty(ctx context.Context, migration Migration) error {
+	const storeDirtySQLQuery = `INSERT INTO %table_name% (version, description, applied_at, checksum, dirty) VALUES(?, ?, ?, ?, TRUE)`
+	_, err := storage.client.ExecContext(
+		ctx,
+		storage.query(storeDirtySQLQuery),
+		migration.Version(),
+		migration.Description(),
+		time.Now(),
+		checksum(migration),
+	)
+	return errors.WithStack(err)
+}
+
+func (storage *storage) MarkDirty(ctx context.Context, version int64, cause error) error {
+	const markDirtySQLQuery = `UPDATE %table_name% SET dirty = TRUE, error_message = ? WHERE version = ?`
+	var message sql.NullString
+	if cause != nil {
+		message = sql.NullString{String: cause.Error(), Valid: true}
+	}
+	_, err := storage.client.ExecContext(ctx, storage.query(markDirtySQLQuery), message, version)
+	return errors.WithStack(err)
+}
+
+func (storage *storage) MarkClean(ctx context.Context, version int64) error {
+	const markCleanSQLQuery = `UPDATE %table_name% SET dirty = FALSE, error_message = NULL, checkpoint = NULL, applied_at = ? WHERE version = ?`
+	_, err := storage.client.ExecContext(ctx, storage.query(markCleanSQLQuery), time.Now(), version)
+	return errors.WithStack(err)
+}
+
+func (storage *storage) Dirty(ctx context.Context) (DirtyMigration, bool, error) {
+	migrations, err := storage.AppliedMigrations(ctx)
+	if err != nil {
+		return DirtyMigration{}, false, err
+	}
+	for _, migration := range migrations {
+		if migration.Dirty {
+			return DirtyMigration{Version: migration.Version, Error: migration.Error.String}, true, nil
+		}
+	}
+	return DirtyMigration{}, false, nil
+}
+
+func (storage *storage) RemoveDirty(ctx context.Context) error {
+	const removeDirtySQLQuery = `DELETE FROM %table_name% WHERE dirty`
+	_, err := storage.client.ExecContext(ctx, storage.query(removeDirtySQLQuery))
+	return errors.WithStack(err)
+}
+
+func (storage *storage) Checkpoint(ctx context.Context, version int64) (string, error) {
+	const checkpointSQLQuery = `SELECT checkpoint FROM %table_name% WHERE version = ?`
+	var checkpoint sql.NullString
+	err := storage.client.GetContext(ctx, &checkpoint, storage.query(checkpointSQLQuery), version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", errors.WithStack(err)
+	}
+	return checkpoint.String, nil
+}
+
+func (storage *storage) SaveCheckpoint(ctx context.Context, version int64, checkpoint string) error {
+	const saveCheckpointSQLQuery = `UPDATE %table_name% SET checkpoint = ? WHERE version = ?`
+	_, err := storage.client.ExecContext(ctx, storage.query(saveCheckpointSQLQuery), checkpoint, version)
+	return errors.WithStack(err)
+}
+
+func (storage *storage) BeginTransaction(ctx context.Context) (mysql.Transaction, error) {
+	switch client := storage.client.(type) {
+	case mysql.TransactionalConnection:
+		return client.BeginTransaction(ctx, nil)
+	case mysql.TransactionalClient:
+		return client.BeginTransaction()
+	default:
+		return nil, errTransactionsNotSupported
+	}
+}
+
+// TransactionalDDL needs a pinned connection, statements of migrations sharing it then join the transaction
+func (storage *storage) TransactionalDDL() bool {
+	_, pinned := storage.client.(mysql.TransactionalConnection)
+	return storage.dialect.transactionalDDL && pinned
+}
+
+func (storage *storage) WithClient(client mysql.ClientContext) Storage {
+	withClient := *storage
+	withClient.client = client
+	return &withClient
+}
+
+func (storage *storage) Remove(ctx context.Context, version int64) error {
+	const removeSQLQuery = `DELETE FROM %table_name% WHERE version = ?`
+	_, err := storage.client.ExecContext(ctx, storage.query(removeSQLQuery), version)
+	return errors.WithStack(err)
+}
+
+func (storage *storage) UpdateMetadata(ctx context.Context, migration Migration) error {
+	const updateMetadataSQLQuery = `UPDATE %table_name% SET description = ?, checksum = ? WHERE version = ?`
+	_, err := storage.client.ExecContext(
+		ctx,
+		storage.query(updateMetadataSQLQuery),
+		migration.Description(),
+		checksum(migration),
+		migration.Version(),
+	)
 	return errors.WithStack(err)
 }
 
 func (storage *storage) tableName() string {
-	return storage.tablePrefix + "_migrations"
+	return storage.tablePrefix + migrationsTableSuffix
+}
+
+func checksum(migration Migration) sql.NullString {
+	checksummed, ok := migration.(ChecksummedMigration)
+	if !ok {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: checksummed.Checksum(), Valid: true}
+}
+
+func (storage *storage) query(query string) string {
+	return storage.rebind(strings.ReplaceAll(query, "%table_name%", storage.dialect.quote(storage.tableName())))
 }
 
-func prepareQuery(query, tableName string) string {
-	return strings.ReplaceAll(query, "%table_name%", tableName)
+func (storage *storage) rebind(query string) string {
+	return sqlx.Rebind(storage.dialect.bindType, query)
 }
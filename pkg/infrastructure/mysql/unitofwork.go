@@ -2,73 +2,312 @@ package mysql
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 
-	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/sharedpool"
-	"gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
+	"github.com/jmoiron/sqlx"
+
+	liberr "gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
 )
 
 type RepositoryProviderBuilder[RepositoryProvider any] func(client ClientContext) RepositoryProvider
 
+type Propagation int
+
+const (
+	// PropagationRequired joins the transaction carried by ctx or starts a new one
+	PropagationRequired Propagation = iota
+	// PropagationRequiresNew always starts an independent transaction on another connection
+	PropagationRequiresNew
+	// PropagationNested runs inside a savepoint of the transaction carried by ctx or starts a new one
+	PropagationNested
+)
+
 type UnitOfWork interface {
+	// ExecuteWithClientContext joins the active transaction unless WithDefaultPropagation says otherwise,
+	// use Execute with PropagationNested to roll back a failed nested call on its own and let the outer callback continue
 	ExecuteWithClientContext(ctx context.Context, callback func(client ClientContext) error) error
+	// Execute passes the callback a context carrying the transaction, calls with contexts derived from it take part in it according to propagation
+	Execute(ctx context.Context, propagation Propagation, callback func(ctx context.Context, client ClientContext) error) error
 }
 
 type UnitOfWorkWithRepositoryProvider[RepositoryProvider any] interface {
 	UnitOfWork
 	ExecuteWithRepositoryProvider(ctx context.Context, callback func(provider RepositoryProvider) error) error
+	ExecuteRepositoryProvider(ctx context.Context, propagation Propagation, callback func(ctx context.Context, provider RepositoryProvider) error) error
 }
 
 func NewUnitOfWork[RepositoryProvider any](
 	pool ConnectionPool,
 	builder RepositoryProviderBuilder[RepositoryProvider],
+	opts ...UnitOfWorkOption,
 ) UnitOfWorkWithRepositoryProvider[RepositoryProvider] {
+	options := unitOfWorkOptions{
+		defaultPropagation: PropagationRequired,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	return &unitOfWork[RepositoryProvider]{
-		pool: sharedpool.NewPool[context.Context, *wrappedTransaction](
-			func(ctx context.Context) (wt *wrappedTransaction, err error) {
-				conn, err := pool.TransactionalConnection(ctx)
-				if err != nil {
-					return nil, err
-				}
-
-				defer func() {
-					if err != nil {
-						err = errors.Join(err, conn.Close())
-					}
-				}()
-
-				transaction, err := conn.BeginTransaction(ctx, nil)
-				if err != nil {
-					return nil, err
-				}
-
-				wt = &wrappedTransaction{
-					Transaction: transaction,
-					state:       commit,
-					connClose:   conn.Close,
-				}
-				return wt, nil
-			},
-		),
-		builder: builder,
+		pool:               pool,
+		builder:            builder,
+		defaultPropagation: options.defaultPropagation,
+		transactions:       &transactionRegistry{transactions: make(map[context.Context]*registeredTransaction)},
+	}
+}
+
+type UnitOfWorkOption func(options *unitOfWorkOptions)
+
+// WithDefaultPropagation sets the propagation of ExecuteWithClientContext and ExecuteWithRepositoryProvider.
+// PropagationNested lets callers of these methods recover from a failed nested call without switching to Execute
+func WithDefaultPropagation(propagation Propagation) UnitOfWorkOption {
+	return func(options *unitOfWorkOptions) {
+		options.defaultPropagation = propagation
 	}
 }
 
+type unitOfWorkOptions struct {
+	defaultPropagation Propagation
+}
+
+type transactionKey struct {
+	pool ConnectionPool
+}
+
+// transactionRegistry keeps calls with exactly the same ctx in one transaction,
+// even when the caller does not use the context passed to the callback.
+// The transaction is closed when the last of those calls returns
+type transactionRegistry struct {
+	mu           sync.Mutex
+	transactions map[context.Context]*registeredTransaction
+}
+
+type registeredTransaction struct {
+	wt   *wrappedTransaction
+	refs int
+}
+
+func (r *transactionRegistry) active(ctx context.Context) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.transactions[ctx]
+	return ok
+}
+
+// acquire joins the transaction registered for ctx or registers the one started by begin,
+// release returns whether it closed a rolled back transaction
+func (r *transactionRegistry) acquire(
+	ctx context.Context,
+	begin func() (*wrappedTransaction, error),
+) (wt *wrappedTransaction, joined bool, release func() (rolledBack bool, err error), err error) {
+	entry := r.join(ctx)
+	if entry == nil {
+		// begin outside the lock, waiting for a connection must not block releases that free one
+		wt, err = begin()
+		if err != nil {
+			return nil, false, nil, err
+		}
+		entry = r.register(ctx, wt)
+		if entry.wt != wt {
+			// another call with the same ctx registered first, join it and drop the unused transaction
+			err = wt.Close()
+			if err != nil {
+				_, releaseErr := r.release(ctx, entry)
+				return nil, false, nil, liberr.Join(err, releaseErr)
+			}
+		}
+	}
+
+	release = func() (bool, error) {
+		return r.release(ctx, entry)
+	}
+	return entry.wt, entry.wt != wt, release, nil
+}
+
+func (r *transactionRegistry) join(ctx context.Context) *registeredTransaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.transactions[ctx]
+	if ok {
+		entry.refs++
+	}
+	return entry
+}
+
+func (r *transactionRegistry) register(ctx context.Context, wt *wrappedTransaction) *registeredTransaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.transactions[ctx]
+	if !ok {
+		entry = &registeredTransaction{wt: wt}
+		r.transactions[ctx] = entry
+	}
+	entry.refs++
+	return entry
+}
+
+func (r *transactionRegistry) release(ctx context.Context, entry *registeredTransaction) (rolledBack bool, err error) {
+	r.mu.Lock()
+	entry.refs--
+	last := entry.refs == 0
+	if last {
+		delete(r.transactions, ctx)
+	}
+	r.mu.Unlock()
+
+	if !last {
+		return false, nil
+	}
+	return entry.wt.rolledBack(), entry.wt.Close()
+}
+
 type unitOfWork[RepositoryProvider any] struct {
-	pool    *sharedpool.Pool[context.Context, *wrappedTransaction]
-	builder RepositoryProviderBuilder[RepositoryProvider]
+	pool               ConnectionPool
+	builder            RepositoryProviderBuilder[RepositoryProvider]
+	defaultPropagation Propagation
+	transactions       *transactionRegistry
+}
+
+func (uow unitOfWork[RepositoryProvider]) ExecuteWithClientContext(ctx context.Context, callback func(client ClientContext) error) error {
+	return uow.Execute(ctx, uow.defaultPropagation, func(_ context.Context, client ClientContext) error {
+		return callback(client)
+	})
+}
+
+func (uow unitOfWork[RepositoryProvider]) ExecuteWithRepositoryProvider(ctx context.Context, callback func(provider RepositoryProvider) error) error {
+	return uow.ExecuteWithClientContext(ctx, func(client ClientContext) error {
+		return callback(uow.builder(client))
+	})
+}
+
+func (uow unitOfWork[RepositoryProvider]) ExecuteRepositoryProvider(
+	ctx context.Context,
+	propagation Propagation,
+	callback func(ctx context.Context, provider RepositoryProvider) error,
+) error {
+	return uow.Execute(ctx, propagation, func(ctx context.Context, client ClientContext) error {
+		return callback(ctx, uow.builder(client))
+	})
+}
+
+func (uow unitOfWork[RepositoryProvider]) Execute(
+	ctx context.Context,
+	propagation Propagation,
+	callback func(ctx context.Context, client ClientContext) error,
+) (err error) {
+	if wt, ok := ctx.Value(transactionKey{pool: uow.pool}).(*wrappedTransaction); ok && wt != nil {
+		return uow.join(ctx, wt, propagation, callback)
+	}
+	if propagation == PropagationRequiresNew && uow.transactions.active(ctx) {
+		return uow.executeNew(ctx, callback)
+	}
+
+	wt, joined, release, err := uow.transactions.acquire(ctx, func() (*wrappedTransaction, error) {
+		return uow.begin(ctx, ctx)
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		rolledBack, releaseErr := release()
+		if err == nil && rolledBack {
+			// a joined call failed, the caller must not report success for work that is rolled back
+			err = ErrTransactionRolledBack
+		}
+		err = liberr.Join(err, releaseErr)
+	}()
+
+	if joined {
+		return uow.join(ctx, wt, propagation, callback)
+	}
+	return uow.run(ctx, wt, callback)
+}
+
+func (uow unitOfWork[RepositoryProvider]) join(
+	ctx context.Context,
+	wt *wrappedTransaction,
+	propagation Propagation,
+	callback func(ctx context.Context, client ClientContext) error,
+) error {
+	switch propagation {
+	case PropagationRequiresNew:
+		return uow.executeNew(ctx, callback)
+	case PropagationNested:
+		return uow.executeNested(ctx, wt, callback)
+	default:
+		return uow.run(ctx, wt, callback)
+	}
 }
 
-func (uow unitOfWork[RepositoryProvider]) ExecuteWithClientContext(ctx context.Context, callback func(client ClientContext) error) (err error) {
-	sharedTransaction, err := uow.pool.Get(ctx)
+func (uow unitOfWork[RepositoryProvider]) begin(ctx, connCtx context.Context) (*wrappedTransaction, error) {
+	conn, err := uow.pool.TransactionalConnection(connCtx)
+	if err != nil {
+		return nil, err
+	}
+	transaction, err := conn.BeginTransaction(ctx, nil)
+	if err != nil {
+		return nil, liberr.Join(err, conn.Close())
+	}
+	return &wrappedTransaction{
+		Transaction: transaction,
+		state:       commit,
+		connClose:   conn.Close,
+	}, nil
+}
+
+// executeNew runs callback in a transaction independent of the one active for ctx
+func (uow unitOfWork[RepositoryProvider]) executeNew(
+	ctx context.Context,
+	callback func(ctx context.Context, client ClientContext) error,
+) (err error) {
+	// connection pool shares connections by ctx, a distinct ctx gets another connection
+	wt, err := uow.begin(ctx, context.WithValue(ctx, transactionKey{pool: uow.pool}, nil))
 	if err != nil {
 		return err
 	}
+	defer func() {
+		rolledBack := wt.rolledBack()
+		closeErr := wt.Close()
+		if err == nil && rolledBack {
+			err = ErrTransactionRolledBack
+		}
+		err = liberr.Join(err, closeErr)
+	}()
 
+	return uow.run(ctx, wt, callback)
+}
+
+func (uow unitOfWork[RepositoryProvider]) executeNested(
+	ctx context.Context,
+	wt *wrappedTransaction,
+	callback func(ctx context.Context, client ClientContext) error,
+) (err error) {
+	savepoint, err := wt.savepoint(ctx)
+	if err != nil {
+		return err
+	}
 	defer func() {
-		err = errors.Join(err, sharedTransaction.Close())
+		if r := recover(); r != nil {
+			err = liberr.Join(err, fmt.Errorf("panic: %v", r), savepoint.rollback(ctx))
+			panic(r)
+		}
+		if err != nil {
+			err = liberr.Join(err, savepoint.rollback(ctx))
+		} else {
+			err = savepoint.release(ctx)
+		}
 	}()
 
+	return callback(context.WithValue(ctx, transactionKey{pool: uow.pool}, wt), wt)
+}
+
+func (uow unitOfWork[RepositoryProvider]) run(
+	ctx context.Context,
+	wt *wrappedTransaction,
+	callback func(ctx context.Context, client ClientContext) error,
+) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("panic: %v", r)
@@ -79,20 +318,57 @@ func (uow unitOfWork[RepositoryProvider]) ExecuteWithClientContext(ctx context.C
 		}
 
 		if err != nil {
-			err = errors.Join(err, sharedTransaction.Value().Rollback())
-		} else {
-			err = errors.Join(err, sharedTransaction.Value().Commit())
+			err = liberr.Join(err, wt.Rollback())
 		}
 	}()
 
-	err = callback(sharedTransaction.Value())
-	return err
+	return callback(context.WithValue(ctx, transactionKey{pool: uow.pool}, wt), wt)
 }
 
-func (uow unitOfWork[RepositoryProvider]) ExecuteWithRepositoryProvider(ctx context.Context, callback func(provider RepositoryProvider) error) (err error) {
-	return uow.ExecuteWithClientContext(ctx, func(client ClientContext) error {
-		return callback(uow.builder(client))
-	})
+var ErrTransactionRolledBack = errors.New("transaction was rolled back because a joined unit of work failed")
+
+var ErrUnmanagedTransaction = errors.New("transaction callbacks need a unit of work transaction or an autocommit client")
+
+// AfterCommit defers callback until the unit of work transaction of client commits.
+// Autocommit clients commit each statement synchronously, so callback runs immediately,
+// other clients such as transactions not started by a unit of work are rejected because their commit cannot be observed
+func AfterCommit(client ClientContext, callback func()) error {
+	if wt, ok := client.(*wrappedTransaction); ok {
+		wt.mu.Lock()
+		defer wt.mu.Unlock()
+		wt.afterCommit = append(wt.afterCommit, callback)
+		return nil
+	}
+	if !autocommit(client) {
+		return ErrUnmanagedTransaction
+	}
+	callback()
+	return nil
+}
+
+// AfterRollback defers callback until the unit of work transaction of client or the savepoint it runs in rolls back.
+// Statements of autocommit clients are already committed, so callback never runs for them,
+// other clients are rejected because their rollback cannot be observed
+func AfterRollback(client ClientContext, callback func()) error {
+	if wt, ok := client.(*wrappedTransaction); ok {
+		wt.mu.Lock()
+		defer wt.mu.Unlock()
+		wt.afterRollback = append(wt.afterRollback, callback)
+		return nil
+	}
+	if !autocommit(client) {
+		return ErrUnmanagedTransaction
+	}
+	return nil
+}
+
+func autocommit(client ClientContext) bool {
+	switch client.(type) {
+	case *sqlx.DB, *sqlx.Conn, TransactionalClient, TransactionalConnection:
+		return true
+	default:
+		return false
+	}
 }
 
 const (
@@ -102,8 +378,14 @@ const (
 
 type wrappedTransaction struct {
 	Transaction
-	state     int
 	connClose func() error
+
+	// mu guards the fields below, calls sharing a ctx use the transaction concurrently
+	mu            sync.Mutex
+	state         int
+	afterCommit   []func()
+	afterRollback []func()
+	savepoints    int
 }
 
 func (wt *wrappedTransaction) Commit() error {
@@ -111,17 +393,105 @@ func (wt *wrappedTransaction) Commit() error {
 }
 
 func (wt *wrappedTransaction) Rollback() error {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
 	wt.state = rollback
 	return nil
 }
 
+func (wt *wrappedTransaction) rolledBack() bool {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+	return wt.state == rollback
+}
+
 func (wt *wrappedTransaction) Close() error {
+	wt.mu.Lock()
+	state, afterCommit, afterRollback := wt.state, wt.afterCommit, wt.afterRollback
+	wt.mu.Unlock()
+
 	var err error
-	switch wt.state {
+	switch state {
 	case commit:
 		err = wt.Transaction.Commit()
+		if err == nil {
+			runCallbacks(afterCommit)
+		} else {
+			runCallbacks(afterRollback)
+		}
 	case rollback:
 		err = wt.Transaction.Rollback()
+		runCallbacks(afterRollback)
+	}
+	return liberr.Join(err, wt.connClose())
+}
+
+func (wt *wrappedTransaction) savepoint(ctx context.Context) (*savepoint, error) {
+	wt.mu.Lock()
+	wt.savepoints++
+	sp := &savepoint{
+		wt:            wt,
+		name:          fmt.Sprintf("uow_savepoint_%d", wt.savepoints),
+		state:         wt.state,
+		afterCommit:   len(wt.afterCommit),
+		afterRollback: len(wt.afterRollback),
+	}
+	wt.mu.Unlock()
+
+	_, err := wt.ExecContext(ctx, "SAVEPOINT "+sp.name)
+	if err != nil {
+		wt.mu.Lock()
+		wt.savepoints--
+		wt.mu.Unlock()
+		return nil, err
+	}
+	return sp, nil
+}
+
+// savepoint names are reused by depth, rollback also releases the savepoint,
+// so a sibling never shares its name with a savepoint still held on any database
+type savepoint struct {
+	wt            *wrappedTransaction
+	name          string
+	state         int
+	afterCommit   int
+	afterRollback int
+}
+
+func (sp *savepoint) release(ctx context.Context) error {
+	sp.wt.mu.Lock()
+	sp.wt.savepoints--
+	sp.wt.mu.Unlock()
+
+	_, err := sp.wt.ExecContext(ctx, "RELEASE SAVEPOINT "+sp.name)
+	return err
+}
+
+func (sp *savepoint) rollback(ctx context.Context) error {
+	_, err := sp.wt.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+sp.name)
+	if err == nil {
+		_, err = sp.wt.ExecContext(ctx, "RELEASE SAVEPOINT "+sp.name)
+	}
+
+	sp.wt.mu.Lock()
+	sp.wt.savepoints--
+	if err != nil {
+		sp.wt.state = rollback
+		sp.wt.mu.Unlock()
+		return err
+	}
+	sp.wt.state = sp.state
+	sp.wt.afterCommit = sp.wt.afterCommit[:sp.afterCommit]
+	rolledBack := sp.wt.afterRollback[sp.afterRollback:]
+	sp.wt.afterRollback = sp.wt.afterRollback[:sp.afterRollback]
+	sp.wt.mu.Unlock()
+
+	runCallbacks(rolledBack)
+	return nil
+}
+
+func runCallbacks(callbacks []func()) {
+	for _, callback := range callbacks {
+		callback()
 	}
-	return errors.Join(err, wt.connClose())
 }
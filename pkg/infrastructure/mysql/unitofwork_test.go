@@ -0,0 +1,361 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	// include sqlite driver
+	_ "modernc.org/sqlite"
+)
+
+func newSQLiteUnitOfWork(t *testing.T, opts ...UnitOfWorkOption) (UnitOfWorkWithRepositoryProvider[ClientContext], *sqlx.DB) {
+	t.Helper()
+	db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "uow.db")+"?_pragma=busy_timeout(5000)")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	_, err = db.Exec("CREATE TABLE item (name TEXT NOT NULL)")
+	require.NoError(t, err)
+
+	pool := NewConnectionPool(NewTransactionalClientFromSQLx(db))
+	uow := NewUnitOfWork(pool, func(client ClientContext) ClientContext {
+		return client
+	}, opts...)
+	return uow, db
+}
+
+func insertItem(ctx context.Context, client ClientContext, name string) error {
+	_, err := client.ExecContext(ctx, "INSERT INTO item (name) VALUES (?)", name)
+	return err
+}
+
+func items(t *testing.T, db *sqlx.DB) []string {
+	t.Helper()
+	var names []string
+	require.NoError(t, db.Select(&names, "SELECT name FROM item ORDER BY name"))
+	return names
+}
+
+func TestUnitOfWork(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	t.Run("derived context joins transaction", func(t *testing.T) {
+		uow, db := newSQLiteUnitOfWork(t)
+
+		err := uow.Execute(t.Context(), PropagationRequired, func(ctx context.Context, client ClientContext) error {
+			require.NoError(t, insertItem(ctx, client, "a"))
+			child, cancel := context.WithTimeout(ctx, time.Minute)
+			defer cancel()
+			require.NoError(t, uow.ExecuteWithClientContext(child, func(inner ClientContext) error {
+				assert.Same(t, client, inner)
+				return insertItem(child, inner, "b")
+			}))
+			return errFailed
+		})
+
+		assert.ErrorIs(t, err, errFailed)
+		assert.Empty(t, items(t, db))
+	})
+
+	t.Run("same context joins transaction", func(t *testing.T) {
+		uow, db := newSQLiteUnitOfWork(t)
+		ctx := t.Context()
+
+		err := uow.ExecuteWithClientContext(ctx, func(client ClientContext) error {
+			return uow.ExecuteWithClientContext(ctx, func(inner ClientContext) error {
+				assert.Same(t, client, inner)
+				return insertItem(ctx, inner, "a")
+			})
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, items(t, db))
+	})
+
+	t.Run("concurrent calls with same context share transaction until last returns", func(t *testing.T) {
+		uow, db := newSQLiteUnitOfWork(t)
+		ctx := t.Context()
+		const callers = 4
+
+		var entered sync.WaitGroup
+		entered.Add(callers)
+		errs := make(chan error, callers)
+		for i := range callers {
+			go func() {
+				errs <- uow.ExecuteWithClientContext(ctx, func(client ClientContext) error {
+					entered.Done()
+					entered.Wait()
+					return insertItem(ctx, client, strconv.Itoa(i))
+				})
+			}()
+		}
+		for range callers {
+			require.NoError(t, <-errs)
+		}
+
+		assert.Equal(t, []string{"0", "1", "2", "3"}, items(t, db))
+	})
+
+	t.Run("requires new commits independently", func(t *testing.T) {
+		uow, db := newSQLiteUnitOfWork(t)
+
+		err := uow.Execute(t.Context(), PropagationRequired, func(ctx context.Context, client ClientContext) error {
+			require.NoError(t, uow.Execute(ctx, PropagationRequiresNew, func(ctx context.Context, inner ClientContext) error {
+				assert.NotSame(t, client, inner)
+				return insertItem(ctx, inner, "audit")
+			}))
+			require.NoError(t, insertItem(ctx, client, "a"))
+			return errFailed
+		})
+
+		assert.ErrorIs(t, err, errFailed)
+		assert.Equal(t, []string{"audit"}, items(t, db))
+	})
+
+	t.Run("reports rollback when joined failure is ignored", func(t *testing.T) {
+		uow, db := newSQLiteUnitOfWork(t)
+
+		err := uow.Execute(t.Context(), PropagationRequired, func(ctx context.Context, client ClientContext) error {
+			require.NoError(t, insertItem(ctx, client, "a"))
+			err := uow.Execute(ctx, PropagationRequired, func(context.Context, ClientContext) error {
+				return errFailed
+			})
+			assert.ErrorIs(t, err, errFailed)
+			return nil
+		})
+
+		assert.ErrorIs(t, err, ErrTransactionRolledBack)
+		assert.Empty(t, items(t, db))
+	})
+
+	t.Run("nested rolls back to savepoint", func(t *testing.T) {
+		uow, db := newSQLiteUnitOfWork(t)
+
+		err := uow.Execute(t.Context(), PropagationRequired, func(ctx context.Context, client ClientContext) error {
+			require.NoError(t, insertItem(ctx, client, "a"))
+			err := uow.Execute(ctx, PropagationNested, func(ctx context.Context, client ClientContext) error {
+				require.NoError(t, insertItem(ctx, client, "b"))
+				return errFailed
+			})
+			assert.ErrorIs(t, err, errFailed)
+			return insertItem(ctx, client, "c")
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "c"}, items(t, db))
+	})
+
+	t.Run("sibling savepoints roll back independently", func(t *testing.T) {
+		uow, db := newSQLiteUnitOfWork(t)
+		nested := func(ctx context.Context, name string, inner func(ctx context.Context) error) error {
+			return uow.Execute(ctx, PropagationNested, func(ctx context.Context, client ClientContext) error {
+				require.NoError(t, insertItem(ctx, client, name))
+				return inner(ctx)
+			})
+		}
+		fail := func(context.Context) error {
+			return errFailed
+		}
+
+		err := uow.Execute(t.Context(), PropagationRequired, func(ctx context.Context, client ClientContext) error {
+			require.NoError(t, insertItem(ctx, client, "a"))
+			assert.ErrorIs(t, nested(ctx, "b", fail), errFailed)
+			require.NoError(t, nested(ctx, "c", func(ctx context.Context) error {
+				assert.ErrorIs(t, nested(ctx, "d", fail), errFailed)
+				return nested(ctx, "e", func(context.Context) error {
+					return nil
+				})
+			}))
+			assert.ErrorIs(t, nested(ctx, "f", fail), errFailed)
+			return nested(ctx, "g", func(context.Context) error {
+				return nil
+			})
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "c", "e", "g"}, items(t, db))
+	})
+
+	t.Run("default propagation option nests calls without context", func(t *testing.T) {
+		uow, db := newSQLiteUnitOfWork(t, WithDefaultPropagation(PropagationNested))
+		ctx := t.Context()
+
+		err := uow.ExecuteWithClientContext(ctx, func(client ClientContext) error {
+			require.NoError(t, insertItem(ctx, client, "a"))
+			assert.ErrorIs(t, uow.ExecuteWithClientContext(ctx, func(client ClientContext) error {
+				require.NoError(t, insertItem(ctx, client, "b"))
+				return errFailed
+			}), errFailed)
+			return insertItem(ctx, client, "c")
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "c"}, items(t, db))
+	})
+
+	t.Run("failed joined unit of work aborts outer one by default", func(t *testing.T) {
+		uow, db := newSQLiteUnitOfWork(t)
+		ctx := t.Context()
+
+		err := uow.ExecuteWithClientContext(ctx, func(client ClientContext) error {
+			require.NoError(t, insertItem(ctx, client, "a"))
+			assert.ErrorIs(t, uow.ExecuteWithClientContext(ctx, func(ClientContext) error {
+				return errFailed
+			}), errFailed)
+			return nil
+		})
+
+		assert.ErrorIs(t, err, ErrTransactionRolledBack)
+		assert.Empty(t, items(t, db))
+	})
+
+	t.Run("failed nested unit of work does not abort outer one", func(t *testing.T) {
+		uow, db := newSQLiteUnitOfWork(t)
+		ctx := t.Context()
+		var committed []string
+
+		err := uow.ExecuteWithClientContext(ctx, func(client ClientContext) error {
+			require.NoError(t, insertItem(ctx, client, "a"))
+			require.NoError(t, AfterCommit(client, func() { committed = append(committed, "a") }))
+			err := uow.Execute(ctx, PropagationNested, func(ctx context.Context, client ClientContext) error {
+				require.NoError(t, uow.ExecuteWithClientContext(ctx, func(client ClientContext) error {
+					return insertItem(ctx, client, "b")
+				}))
+				require.NoError(t, AfterCommit(client, func() { committed = append(committed, "b") }))
+				return errFailed
+			})
+			assert.ErrorIs(t, err, errFailed)
+
+			require.NoError(t, uow.ExecuteWithClientContext(ctx, func(client ClientContext) error {
+				require.NoError(t, AfterCommit(client, func() { committed = append(committed, "c") }))
+				return insertItem(ctx, client, "c")
+			}))
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "c"}, items(t, db))
+		assert.Equal(t, []string{"a", "c"}, committed)
+	})
+}
+
+func TestAfterCommit(t *testing.T) {
+	t.Run("waits for unit of work commit", func(t *testing.T) {
+		uow, _ := newSQLiteUnitOfWork(t)
+		var calls int
+
+		err := uow.ExecuteWithClientContext(t.Context(), func(client ClientContext) error {
+			require.NoError(t, AfterCommit(client, func() { calls++ }))
+			assert.Zero(t, calls)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, calls)
+
+		err = uow.ExecuteWithClientContext(t.Context(), func(client ClientContext) error {
+			require.NoError(t, AfterCommit(client, func() { calls++ }))
+			return errors.New("failed")
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("runs immediately for autocommit client", func(t *testing.T) {
+		_, db := newSQLiteUnitOfWork(t)
+		var called bool
+
+		require.NoError(t, AfterCommit(db, func() { called = true }))
+		assert.True(t, called)
+	})
+
+	t.Run("rejects transaction outside unit of work", func(t *testing.T) {
+		_, db := newSQLiteUnitOfWork(t)
+		tx, err := db.Beginx()
+		require.NoError(t, err)
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		var called bool
+
+		assert.ErrorIs(t, AfterCommit(tx, func() { called = true }), ErrUnmanagedTransaction)
+		assert.False(t, called)
+	})
+}
+
+func TestAfterRollback(t *testing.T) {
+	t.Run("runs when unit of work rolls back", func(t *testing.T) {
+		uow, _ := newSQLiteUnitOfWork(t)
+		var calls int
+
+		err := uow.ExecuteWithClientContext(t.Context(), func(client ClientContext) error {
+			require.NoError(t, AfterRollback(client, func() { calls++ }))
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Zero(t, calls)
+
+		err = uow.ExecuteWithClientContext(t.Context(), func(client ClientContext) error {
+			require.NoError(t, AfterRollback(client, func() { calls++ }))
+			assert.Zero(t, calls)
+			return errors.New("failed")
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("runs when savepoint rolls back", func(t *testing.T) {
+		uow, _ := newSQLiteUnitOfWork(t)
+		var calls int
+
+		err := uow.Execute(t.Context(), PropagationRequired, func(ctx context.Context, _ ClientContext) error {
+			err := uow.Execute(ctx, PropagationNested, func(_ context.Context, client ClientContext) error {
+				require.NoError(t, AfterRollback(client, func() { calls++ }))
+				return errors.New("failed")
+			})
+			assert.Error(t, err)
+			assert.Equal(t, 1, calls)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("never runs for autocommit client", func(t *testing.T) {
+		_, db := newSQLiteUnitOfWork(t)
+		var called bool
+
+		require.NoError(t, AfterRollback(db, func() { called = true }))
+		assert.False(t, called)
+	})
+
+	t.Run("rejects transaction outside unit of work", func(t *testing.T) {
+		_, db := newSQLiteUnitOfWork(t)
+		tx, err := db.Beginx()
+		require.NoError(t, err)
+		defer func() {
+			_ = tx.Rollback()
+		}()
+
+		assert.ErrorIs(t, AfterRollback(tx, func() {}), ErrUnmanagedTransaction)
+	})
+	t.Run("rejects decorated unit of work client", func(t *testing.T) {
+		uow, _ := newSQLiteUnitOfWork(t)
+		type decoratedClient struct {
+			ClientContext
+		}
+
+		err := uow.ExecuteWithClientContext(t.Context(), func(client ClientContext) error {
+			return AfterRollback(decoratedClient{client}, func() {})
+		})
+		assert.ErrorIs(t, err, ErrUnmanagedTransaction)
+	})
+}
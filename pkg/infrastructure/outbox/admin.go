@@ -0,0 +1,185 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	liberr "gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/helpers"
+)
+
+var ErrInvalidReplayRange = errors.New("replay range must start at event 1 or later and not end before it starts")
+
+type Backlog struct {
+	LastEventID        uint64
+	LastTrackedEventID uint64
+	Size               uint64
+	OldestEventAge     time.Duration
+}
+
+type Administrator interface {
+	Cursor(ctx context.Context) (uint64, error)
+	ResetCursor(ctx context.Context, eventID uint64) error
+	ResetCursorToTime(ctx context.Context, createdAt time.Time) error
+	Replay(ctx context.Context, fromEventID, toEventID uint64) error
+	Backlog(ctx context.Context) (Backlog, error)
+}
+
+type AdministratorConfig struct {
+	OutboxName     string
+	TransportName  string
+	Transport      Transport
+	ConnectionPool mysql.ConnectionPool
+	Storage        Storage
+	Locker         Locker
+	BatchSize      *uint
+	LockTimeout    *time.Duration
+}
+
+func NewAdministrator(config AdministratorConfig) (Administrator, error) {
+	if config.OutboxName == "" {
+		config.OutboxName = config.TransportName
+	}
+	err := validateNames(config.OutboxName, config.TransportName)
+	if err != nil {
+		return nil, err
+	}
+	if config.Storage == nil {
+		config.Storage = NewMySQLStorage(config.OutboxName)
+	}
+	if config.Locker == nil {
+		config.Locker = mysql.NewLocker(config.ConnectionPool)
+	}
+	if config.BatchSize == nil {
+		config.BatchSize = helpers.ToPtr(uint(1000))
+	}
+	if config.LockTimeout == nil {
+		config.LockTimeout = helpers.ToPtr(time.Minute)
+	}
+
+	return &administrator{
+		outboxName:    config.OutboxName,
+		transportName: config.TransportName,
+		transport:     config.Transport,
+		batchSize:     *config.BatchSize,
+		pool:          config.ConnectionPool,
+		locker:        config.Locker,
+		lockTimeout:   *config.LockTimeout,
+		storage:       config.Storage,
+	}, nil
+}
+
+type administrator struct {
+	outboxName    string
+	transportName string
+	transport     Transport
+	batchSize     uint
+
+	pool        mysql.ConnectionPool
+	locker      Locker
+	lockTimeout time.Duration
+	storage     Storage
+}
+
+func (a administrator) Cursor(ctx context.Context) (cursor uint64, err error) {
+	err = a.executeWithLock(ctx, func(conn mysql.TransactionalConnection) error {
+		tracked, err := a.storage.Cursor(ctx, conn, a.transportName)
+		cursor = tracked.LastTrackedEventID
+		return err
+	})
+	return cursor, err
+}
+
+func (a administrator) ResetCursor(ctx context.Context, eventID uint64) error {
+	return a.executeWithLock(ctx, func(conn mysql.TransactionalConnection) error {
+		return a.storage.TrackEvent(ctx, conn, a.transportName, eventID)
+	})
+}
+
+func (a administrator) ResetCursorToTime(ctx context.Context, createdAt time.Time) error {
+	return a.executeWithLock(ctx, func(conn mysql.TransactionalConnection) error {
+		eventID, err := a.storage.LastEventIDBefore(ctx, conn, createdAt)
+		if err != nil {
+			return err
+		}
+		return a.storage.TrackEvent(ctx, conn, a.transportName, eventID)
+	})
+}
+
+func (a administrator) Replay(ctx context.Context, fromEventID, toEventID uint64) error {
+	if fromEventID == 0 || fromEventID > toEventID {
+		return fmt.Errorf("%w: from %d to %d", ErrInvalidReplayRange, fromEventID, toEventID)
+	}
+	return a.executeWithLock(ctx, func(conn mysql.TransactionalConnection) error {
+		after := fromEventID - 1
+		for {
+			events, err := a.storage.EventsRange(ctx, conn, after, toEventID, a.batchSize)
+			if err != nil {
+				return err
+			}
+			for _, event := range events {
+				err = a.transport.HandleEvents(ctx, event)
+				if err != nil {
+					return err
+				}
+				after = event.EventID
+			}
+			if uint(len(events)) < a.batchSize {
+				return nil
+			}
+		}
+	})
+}
+
+func (a administrator) Backlog(ctx context.Context) (b Backlog, err error) {
+	err = a.executeWithLock(ctx, func(conn mysql.TransactionalConnection) error {
+		tracked, err := a.storage.Cursor(ctx, conn, a.transportName)
+		if err != nil {
+			return err
+		}
+		b, err = backlog(ctx, a.storage, conn, tracked.LastTrackedEventID)
+		return err
+	})
+	return b, err
+}
+
+func (a administrator) executeWithLock(ctx context.Context, callback func(conn mysql.TransactionalConnection) error) error {
+	return a.locker.ExecuteWithLock(ctx, handlerLockName(a.outboxName, a.transportName), a.lockTimeout, func() (err error) {
+		conn, err := a.pool.TransactionalConnection(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = liberr.Join(err, conn.Close())
+		}()
+
+		return callback(conn)
+	})
+}
+
+func backlog(ctx context.Context, storage EventStorage, client mysql.ClientContext, lastTracked uint64) (Backlog, error) {
+	lastEventID, err := storage.LastEventID(ctx, client)
+	if err != nil {
+		return Backlog{}, err
+	}
+	b := Backlog{
+		LastEventID:        lastEventID,
+		LastTrackedEventID: lastTracked,
+	}
+	if lastEventID <= lastTracked {
+		return b, nil
+	}
+	b.Size = lastEventID - lastTracked
+
+	oldestCreatedAt, ok, err := storage.OldestEventCreatedAt(ctx, client, lastTracked)
+	if err != nil {
+		return Backlog{}, err
+	}
+	if ok {
+		b.OldestEventAge = time.Since(oldestCreatedAt)
+	}
+	return b, nil
+}
@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdministrator(t *testing.T) {
+	t.Run("replays range without moving cursor", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		o.dispatch(t, t.Context(), "first", "second", "third")
+		o.sendEvents(t, o.handler(t, 0))
+		admin := o.administrator(t)
+		first := o.transport.messages[0].EventID
+		third := o.transport.messages[2].EventID
+
+		require.NoError(t, admin.Replay(t.Context(), first+1, third))
+		assert.Equal(t, []string{"first", "second", "third", "second", "third"}, o.transport.eventTypes())
+
+		cursor, err := admin.Cursor(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, third, cursor)
+	})
+
+	t.Run("rejects invalid replay range", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		admin := o.administrator(t)
+
+		assert.ErrorIs(t, admin.Replay(t.Context(), 0, 10), ErrInvalidReplayRange)
+		assert.ErrorIs(t, admin.Replay(t.Context(), 5, 4), ErrInvalidReplayRange)
+		assert.NoError(t, admin.Replay(t.Context(), 4, 4))
+	})
+
+	t.Run("requeues events by resetting cursor", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		o.dispatch(t, t.Context(), "first", "second")
+		h := o.handler(t, 0)
+		o.sendEvents(t, h)
+		admin := o.administrator(t)
+
+		require.NoError(t, admin.ResetCursor(t.Context(), o.transport.messages[0].EventID))
+		backlog, err := admin.Backlog(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), backlog.Size)
+		o.sendEvents(t, h)
+		assert.Equal(t, []string{"first", "second", "second"}, o.transport.eventTypes())
+
+		require.NoError(t, admin.ResetCursorToTime(t.Context(), time.Now().Add(-time.Hour)))
+		o.sendEvents(t, h)
+		assert.Equal(t, []string{"first", "second", "second", "first", "second"}, o.transport.eventTypes())
+	})
+}
@@ -0,0 +1,74 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/identifier"
+)
+
+// forUpdate claims the selected rows, PostgreSQL skips rows claimed by another transaction,
+// MySQL waits for them because SKIP LOCKED needs MySQL 8
+type dialect struct {
+	bindType  int
+	quote     func(name string) string
+	forUpdate string
+	upsert    func(insert string, conflictColumns []string, updateColumns ...string) string
+	sequence  func(ctx context.Context, client mysql.ClientContext) (Sequence, error)
+}
+
+var mysqlDialect = dialect{
+	bindType:  sqlx.QUESTION,
+	quote:     identifier.QuoteMySQL,
+	forUpdate: " FOR UPDATE",
+	upsert: func(insert string, _ []string, updateColumns ...string) string {
+		assignments := make([]string, 0, len(updateColumns))
+		for _, column := range updateColumns {
+			assignments = append(assignments, fmt.Sprintf("%[1]s = VALUES(%[1]s)", column))
+		}
+		return insert + " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+	},
+	sequence: func(ctx context.Context, client mysql.ClientContext) (Sequence, error) {
+		var sequence Sequence
+		err := client.GetContext(ctx, &sequence, `
+			SELECT @@auto_increment_increment AS auto_increment_increment, @@auto_increment_offset AS auto_increment_offset
+		`)
+		return sequence, err
+	},
+}
+
+var postgresDialect = dialect{
+	bindType:  sqlx.DOLLAR,
+	quote:     identifier.QuoteANSI,
+	forUpdate: " FOR UPDATE SKIP LOCKED",
+	upsert:    onConflictUpsert,
+	sequence:  defaultSequence,
+}
+
+var sqliteDialect = dialect{
+	bindType: sqlx.QUESTION,
+	quote:    identifier.QuoteANSI,
+	upsert:   onConflictUpsert,
+	sequence: defaultSequence,
+}
+
+func onConflictUpsert(insert string, conflictColumns []string, updateColumns ...string) string {
+	assignments := make([]string, 0, len(updateColumns))
+	for _, column := range updateColumns {
+		assignments = append(assignments, fmt.Sprintf("%[1]s = excluded.%[1]s", column))
+	}
+	return fmt.Sprintf(
+		"%s ON CONFLICT (%s) DO UPDATE SET %s",
+		insert,
+		strings.Join(conflictColumns, ", "),
+		strings.Join(assignments, ", "),
+	)
+}
+
+func defaultSequence(context.Context, mysql.ClientContext) (Sequence, error) {
+	return Sequence{Increment: 1, Offset: 1}, nil
+}
@@ -0,0 +1,58 @@
+package outbox
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/sqltest"
+)
+
+func TestStorageDialects(t *testing.T) {
+	testCases := []struct {
+		name        string
+		storage     Storage
+		parkedEvent string
+		trackEvent  string
+	}{
+		{
+			name:        "mysql",
+			storage:     NewMySQLStorage("test"),
+			parkedEvent: "FROM `outbox_test_parked_event` WHERE transport_name = ? AND event_id = ? FOR UPDATE",
+			trackEvent: "INSERT INTO `outbox_test_tracked_event` (transport_name, last_tracked_event_id, failed_event_id, failed_attempts) VALUES (?, ?, NULL, 0)" +
+				" ON DUPLICATE KEY UPDATE last_tracked_event_id = VALUES(last_tracked_event_id), failed_event_id = VALUES(failed_event_id), failed_attempts = VALUES(failed_attempts)",
+		},
+		{
+			name:        "postgres",
+			storage:     NewPostgreSQLStorage("test"),
+			parkedEvent: `FROM "outbox_test_parked_event" WHERE transport_name = $1 AND event_id = $2 FOR UPDATE SKIP LOCKED`,
+			trackEvent: `INSERT INTO "outbox_test_tracked_event" (transport_name, last_tracked_event_id, failed_event_id, failed_attempts) VALUES ($1, $2, NULL, 0)` +
+				` ON CONFLICT (transport_name) DO UPDATE SET last_tracked_event_id = excluded.last_tracked_event_id, failed_event_id = excluded.failed_event_id, failed_attempts = excluded.failed_attempts`,
+		},
+		{
+			name:        "sqlite",
+			storage:     NewSQLiteStorage("test"),
+			parkedEvent: `FROM "outbox_test_parked_event" WHERE transport_name = ? AND event_id = ?`,
+			trackEvent: `INSERT INTO "outbox_test_tracked_event" (transport_name, last_tracked_event_id, failed_event_id, failed_attempts) VALUES (?, ?, NULL, 0)` +
+				` ON CONFLICT (transport_name) DO UPDATE SET last_tracked_event_id = excluded.last_tracked_event_id, failed_event_id = excluded.failed_event_id, failed_attempts = excluded.failed_attempts`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &sqltest.RecordingClient{Get: func(interface{}, string) error {
+				return sql.ErrNoRows
+			}}
+
+			_, err := tc.storage.ParkedEvent(t.Context(), client, "transport", 1)
+			require.ErrorIs(t, err, ErrParkedEventNotFound)
+			require.NoError(t, tc.storage.TrackEvent(t.Context(), client, "transport", 1))
+
+			require.Len(t, client.Queries, 2)
+			assert.True(t, strings.HasSuffix(client.Queries[0], tc.parkedEvent), client.Queries[0])
+			assert.Equal(t, tc.trackEvent, client.Queries[1])
+		})
+	}
+}
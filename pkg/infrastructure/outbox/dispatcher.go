@@ -2,66 +2,214 @@ package outbox
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/claimcheck"
 	"gitea.xscloud.ru/xscloud/golib/pkg/application/outbox"
 	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/outbox/internal/outboxname"
+	liberr "gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
 )
 
+var ErrNoUnitOfWork = errors.New("dispatcher has no unit of work, use DispatchWith")
+
+type EventDispatcher[E outbox.Event] interface {
+	outbox.EventDispatcher[E]
+	DispatchWith(ctx context.Context, client mysql.ClientContext, event E) error
+}
+
 func NewEventDispatcher[E outbox.Event](
 	appID string,
-	transportName string,
+	outboxName string,
 	serializer outbox.EventSerializer[E],
 	uow mysql.UnitOfWork,
-) outbox.EventDispatcher[E] {
-	if transportName == "" {
-		panic("transport name cannot be empty")
+	opts ...DispatcherOption,
+) (EventDispatcher[E], error) {
+	err := outboxname.Validate(outboxName)
+	if err != nil {
+		return nil, err
+	}
+
+	options := dispatcherOptions{
+		storage: NewMySQLStorage(outboxName),
+	}
+	for _, opt := range opts {
+		opt(&options)
 	}
 
 	return &eventDispatcher[E]{
-		appID:         appID,
-		transportName: transportName,
-		serializer:    serializer,
-		uow:           uow,
+		appID:      appID,
+		outboxName: outboxName,
+		serializer: serializer,
+		uow:        uow,
+		notifier:   options.notifier,
+		storage:    options.storage,
+
+		compressionThreshold: options.compressionThreshold,
+		maxPayloadSize:       options.maxPayloadSize,
+		claimCheck:           options.claimCheck,
+	}, nil
+}
+
+type DispatcherOption func(options *dispatcherOptions)
+
+func WithNotifier(notifier Notifier) DispatcherOption {
+	return func(options *dispatcherOptions) {
+		options.notifier = notifier
+	}
+}
+
+func WithStorage(storage EventStorage) DispatcherOption {
+	return func(options *dispatcherOptions) {
+		options.storage = storage
+	}
+}
+
+func WithCompression(threshold uint) DispatcherOption {
+	return func(options *dispatcherOptions) {
+		options.compressionThreshold = &threshold
+	}
+}
+
+func WithMaxPayloadSize(size uint) DispatcherOption {
+	return func(options *dispatcherOptions) {
+		options.maxPayloadSize = &size
 	}
 }
 
+func WithClaimCheck(store claimcheck.BlobStore, threshold uint) DispatcherOption {
+	return func(options *dispatcherOptions) {
+		options.claimCheck = &claimCheckOptions{
+			store:     store,
+			threshold: threshold,
+		}
+	}
+}
+
+type claimCheckOptions struct {
+	store     claimcheck.BlobStore
+	threshold uint
+}
+
+type dispatcherOptions struct {
+	notifier             Notifier
+	storage              EventStorage
+	compressionThreshold *uint
+	maxPayloadSize       *uint
+	claimCheck           *claimCheckOptions
+}
+
 type eventDispatcher[E outbox.Event] struct {
-	appID         string
-	transportName string
-	serializer    outbox.EventSerializer[E]
-	uow           mysql.UnitOfWork
+	appID      string
+	outboxName string
+	serializer outbox.EventSerializer[E]
+	uow        mysql.UnitOfWork
+	notifier   Notifier
+	storage    EventStorage
+
+	compressionThreshold *uint
+	maxPayloadSize       *uint
+	claimCheck           *claimCheckOptions
 }
 
 func (d *eventDispatcher[E]) Dispatch(ctx context.Context, event E) error {
-	msg, err := d.serializer.Serialize(event)
-	if err != nil {
-		return err
+	if d.uow == nil {
+		return ErrNoUnitOfWork
 	}
 
-	correlationID, err := newCorrelationID(d.appID, msg)
+	return d.uow.ExecuteWithClientContext(ctx, func(client mysql.ClientContext) error {
+		return d.dispatch(ctx, client, event)
+	})
+}
+
+func (d *eventDispatcher[E]) DispatchWith(ctx context.Context, client mysql.ClientContext, event E) error {
+	return d.dispatch(ctx, client, event)
+}
+
+func (d *eventDispatcher[E]) dispatch(ctx context.Context, client mysql.ClientContext, event E) (err error) {
+	message, blobReference, err := d.newMessage(ctx, event)
 	if err != nil {
 		return err
 	}
+	if blobReference == "" {
+		return d.append(ctx, client, message)
+	}
 
-	return d.append(ctx, storedEvent{
-		CorrelationID: correlationID,
-		EventType:     event.Type(),
-		Payload:       msg,
+	// the event row is gone when the transaction rolls back, so its blob must go too
+	defer func() {
+		if err != nil {
+			err = liberr.Join(err, d.claimCheck.store.Delete(context.WithoutCancel(ctx), blobReference))
+		}
+	}()
+	err = mysql.AfterRollback(client, func() {
+		_ = d.claimCheck.store.Delete(context.WithoutCancel(ctx), blobReference)
 	})
+	if err != nil {
+		return err
+	}
+	return d.append(ctx, client, message)
 }
 
-func (d *eventDispatcher[E]) append(ctx context.Context, event storedEvent) (err error) {
-	return d.uow.ExecuteWithClientContext(ctx, func(client mysql.ClientContext) error {
-		query := fmt.Sprintf(
-			"INSERT INTO outbox_%s_event (correlation_id, event_type, payload) VALUES (?, ?, ?)",
-			d.transportName,
-		)
-		_, err = client.ExecContext(
-			ctx,
-			query,
-			event.CorrelationID, event.EventType, event.Payload,
+func (d *eventDispatcher[E]) newMessage(ctx context.Context, event E) (message Message, blobReference string, err error) {
+	msg, err := d.serializer.Serialize(event)
+	if err != nil {
+		return Message{}, "", err
+	}
+
+	correlationID, err := newCorrelationID(d.appID, msg)
+	if err != nil {
+		return Message{}, "", err
+	}
+
+	if d.claimCheck != nil && uint(len(msg)) > d.claimCheck.threshold {
+		blobReference, err = d.claimCheck.store.Put(ctx, []byte(msg))
+		if err != nil {
+			return Message{}, "", err
+		}
+		defer func() {
+			if err != nil {
+				err = liberr.Join(err, d.claimCheck.store.Delete(context.WithoutCancel(ctx), blobReference))
+			}
+		}()
+		ctx = outbox.WithHeader(ctx, claimcheck.ReferenceHeader, blobReference)
+		msg = ""
+	}
+
+	payload, encoding, err := encodePayload(msg, d.compressionThreshold)
+	if err != nil {
+		return Message{}, "", err
+	}
+	if d.maxPayloadSize != nil && uint(len(payload)) > *d.maxPayloadSize {
+		return Message{}, "", fmt.Errorf(
+			"%w: %s event payload takes %d bytes, limit is %d",
+			ErrPayloadTooLarge, event.Type(), len(payload), *d.maxPayloadSize,
 		)
-		return err
-	})
+	}
+
+	metadata := outbox.MetadataFromContext(ctx)
+	return Message{
+		CorrelationID:   correlationID,
+		EventType:       event.Type(),
+		Payload:         payload,
+		PayloadEncoding: encoding,
+		Headers:         metadata.Headers,
+		SchemaVersion:   metadata.SchemaVersion,
+		CreatedAt:       time.Now(),
+	}, blobReference, nil
+}
+
+func (d *eventDispatcher[E]) append(ctx context.Context, client mysql.ClientContext, message Message) error {
+	// register the notification first, so a client that cannot report its commit is rejected before the insert;
+	// if the insert fails the relay is only woken without new events
+	if d.notifier != nil {
+		err := mysql.AfterCommit(client, func() {
+			d.notifier.Notify(d.outboxName)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return d.storage.Append(ctx, client, message)
 }
@@ -0,0 +1,122 @@
+package outbox
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	infraclaimcheck "gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/claimcheck"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+)
+
+func TestDispatchWith(t *testing.T) {
+	t.Run("wakes relay after unit of work commits", func(t *testing.T) {
+		notifier := NewNotifier()
+		dispatched, unsubscribe := notifier.Subscribe(testTransportName)
+		defer unsubscribe()
+		o := newSQLiteOutbox(t, WithNotifier(notifier))
+
+		err := o.uow.ExecuteWithClientContext(t.Context(), func(client mysql.ClientContext) error {
+			err := o.dispatcher.DispatchWith(t.Context(), client, testEvent{EventType: "created"})
+			assert.Empty(t, dispatched)
+			return err
+		})
+		require.NoError(t, err)
+		require.Len(t, dispatched, 1)
+		<-dispatched
+
+		o.sendEvents(t, o.handler(t, 0))
+		assert.Equal(t, []string{"created"}, o.transport.eventTypes())
+	})
+
+	t.Run("does not wake relay when unit of work rolls back", func(t *testing.T) {
+		notifier := NewNotifier()
+		dispatched, unsubscribe := notifier.Subscribe(testTransportName)
+		defer unsubscribe()
+		o := newSQLiteOutbox(t, WithNotifier(notifier))
+
+		err := o.uow.ExecuteWithClientContext(t.Context(), func(client mysql.ClientContext) error {
+			require.NoError(t, o.dispatcher.DispatchWith(t.Context(), client, testEvent{EventType: "created"}))
+			return assert.AnError
+		})
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Empty(t, dispatched)
+	})
+
+	t.Run("wakes relay immediately for autocommit client", func(t *testing.T) {
+		notifier := NewNotifier()
+		dispatched, unsubscribe := notifier.Subscribe(testTransportName)
+		defer unsubscribe()
+		o := newSQLiteOutbox(t, WithNotifier(notifier))
+
+		require.NoError(t, o.dispatcher.DispatchWith(t.Context(), o.db, testEvent{EventType: "created"}))
+		assert.Len(t, dispatched, 1)
+	})
+
+	t.Run("rejects transaction outside unit of work", func(t *testing.T) {
+		o := newSQLiteOutbox(t, WithNotifier(NewNotifier()))
+		tx, err := o.db.Beginx()
+		require.NoError(t, err)
+		defer func() {
+			_ = tx.Rollback()
+		}()
+
+		err = o.dispatcher.DispatchWith(t.Context(), tx, testEvent{EventType: "created"})
+		assert.ErrorIs(t, err, mysql.ErrUnmanagedTransaction)
+
+		lastEventID, err := NewSQLiteStorage(testTransportName).LastEventID(t.Context(), tx)
+		require.NoError(t, err)
+		assert.Zero(t, lastEventID)
+	})
+}
+
+func TestDispatchClaimCheck(t *testing.T) {
+	large := testEvent{EventType: "large", Value: strings.Repeat("document ", 100)}
+	blobs := func(t *testing.T, dir string) int {
+		t.Helper()
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	t.Run("keeps blob when unit of work commits", func(t *testing.T) {
+		dir := t.TempDir()
+		o := newSQLiteOutbox(t, WithClaimCheck(infraclaimcheck.NewFileSystemBlobStore(dir), 64))
+
+		require.NoError(t, o.dispatcher.Dispatch(t.Context(), large))
+		assert.Equal(t, 1, blobs(t, dir))
+	})
+
+	t.Run("deletes blob when unit of work rolls back", func(t *testing.T) {
+		dir := t.TempDir()
+		o := newSQLiteOutbox(t, WithClaimCheck(infraclaimcheck.NewFileSystemBlobStore(dir), 64))
+
+		err := o.uow.ExecuteWithClientContext(t.Context(), func(client mysql.ClientContext) error {
+			require.NoError(t, o.dispatcher.DispatchWith(t.Context(), client, large))
+			assert.Equal(t, 1, blobs(t, dir))
+			return assert.AnError
+		})
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Zero(t, blobs(t, dir))
+
+		o.sendEvents(t, o.handler(t, 0))
+		assert.Empty(t, o.transport.messages)
+	})
+
+	t.Run("deletes blob when event is rejected", func(t *testing.T) {
+		dir := t.TempDir()
+		o := newSQLiteOutbox(t, WithClaimCheck(infraclaimcheck.NewFileSystemBlobStore(dir), 64))
+		tx, err := o.db.Beginx()
+		require.NoError(t, err)
+		defer func() {
+			_ = tx.Rollback()
+		}()
+
+		err = o.dispatcher.DispatchWith(t.Context(), tx, large)
+		assert.ErrorIs(t, err, mysql.ErrUnmanagedTransaction)
+		assert.Zero(t, blobs(t, dir))
+	})
+}
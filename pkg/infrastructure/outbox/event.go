@@ -1,8 +1,105 @@
 package outbox
 
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/sqltime"
+)
+
+type Message struct {
+	EventID         uint64
+	CorrelationID   string
+	EventType       string
+	Payload         string
+	PayloadEncoding string
+	Headers         map[string]string
+	SchemaVersion   uint
+	CreatedAt       time.Time
+}
+
 type storedEvent struct {
-	EventID       uint64 `db:"event_id"`
-	CorrelationID string `db:"correlation_id"`
-	EventType     string `db:"event_type"`
-	Payload       string `db:"payload"`
+	EventID         uint64       `db:"event_id"`
+	CorrelationID   string       `db:"correlation_id"`
+	EventType       string       `db:"event_type"`
+	Payload         []byte       `db:"payload"`
+	PayloadEncoding string       `db:"payload_encoding"`
+	Headers         eventHeaders `db:"headers"`
+	SchemaVersion   uint         `db:"schema_version"`
+	CreatedAt       sqltime.Time `db:"created_at"`
+}
+
+func messages(events []storedEvent) ([]Message, error) {
+	result := make([]Message, 0, len(events))
+	for _, event := range events {
+		message, err := event.message()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, message)
+	}
+	return result, nil
+}
+
+func (e storedEvent) message() (Message, error) {
+	payload, err := decodePayload(e.Payload, e.PayloadEncoding)
+	if err != nil {
+		return Message{}, errors.Wrapf(err, "decode payload of event %d", e.EventID)
+	}
+	return Message{
+		EventID:       e.EventID,
+		CorrelationID: e.CorrelationID,
+		EventType:     e.EventType,
+		Payload:       payload,
+		Headers:       e.Headers,
+		SchemaVersion: e.SchemaVersion,
+		CreatedAt:     e.CreatedAt.Time,
+	}, nil
+}
+
+type trackedEvent struct {
+	LastTrackedEventID uint64        `db:"last_tracked_event_id"`
+	FailedEventID      sql.NullInt64 `db:"failed_event_id"`
+	FailedAttempts     uint          `db:"failed_attempts"`
+}
+
+func (e trackedEvent) cursor() Cursor {
+	return Cursor{
+		LastTrackedEventID: e.LastTrackedEventID,
+		FailedEventID:      uint64(e.FailedEventID.Int64),
+		FailedAttempts:     e.FailedAttempts,
+	}
+}
+
+type eventHeaders map[string]string
+
+func (h eventHeaders) Value() (driver.Value, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(map[string]string(h))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return string(data), nil
+}
+
+func (h *eventHeaders) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.Errorf("unsupported headers type %T", src)
+	}
+	return errors.WithStack(json.Unmarshal(data, (*map[string]string)(h)))
 }
@@ -0,0 +1,70 @@
+package outbox
+
+import "time"
+
+// Event IDs come from AUTO_INCREMENT and are allocated before the inserting transaction commits,
+// so a missing ID is either an in-flight transaction or a rolled back one.
+// gapTracker holds the cursor in front of a gap until the gap is filled or times out.
+type gapTracker struct {
+	timeout time.Duration
+	now     func() time.Time
+	gaps    map[uint64]time.Time
+}
+
+type Sequence struct {
+	Increment uint64 `db:"auto_increment_increment"`
+	Offset    uint64 `db:"auto_increment_offset"`
+}
+
+func (a Sequence) next(eventID uint64) uint64 {
+	if eventID == 0 {
+		return a.Offset
+	}
+	return eventID + a.Increment
+}
+
+type gapRange struct {
+	From uint64
+	To   uint64
+}
+
+func newGapTracker(timeout time.Duration) *gapTracker {
+	return &gapTracker{
+		timeout: timeout,
+		now:     time.Now,
+		gaps:    make(map[uint64]time.Time),
+	}
+}
+
+// relayable returns how many of the ordered eventIDs can be relayed after lastTracked,
+// whether relaying stopped at a gap that is still awaited, and the gaps declared dead on the way.
+func (t *gapTracker) relayable(
+	lastTracked uint64,
+	sequence Sequence,
+	eventIDs []uint64,
+) (count int, waiting bool, skipped []gapRange) {
+	for gapStart := range t.gaps {
+		if gapStart <= lastTracked {
+			delete(t.gaps, gapStart)
+		}
+	}
+
+	now := t.now()
+	prev := lastTracked
+	for i, eventID := range eventIDs {
+		expected := sequence.next(prev)
+		if eventID > expected {
+			firstSeen, ok := t.gaps[expected]
+			if !ok {
+				t.gaps[expected] = now
+				return i, true, skipped
+			}
+			if now.Sub(firstSeen) < t.timeout {
+				return i, true, skipped
+			}
+			skipped = append(skipped, gapRange{From: expected, To: eventID - 1})
+		}
+		prev = eventID
+	}
+	return len(eventIDs), false, skipped
+}
@@ -0,0 +1,176 @@
+package outbox
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type simulatedTable struct {
+	sequence  Sequence
+	lastID    uint64
+	committed []uint64
+}
+
+func (t *simulatedTable) begin() uint64 {
+	t.lastID = t.sequence.next(t.lastID)
+	return t.lastID
+}
+
+func (t *simulatedTable) commit(eventID uint64) {
+	t.committed = append(t.committed, eventID)
+	slices.Sort(t.committed)
+}
+
+func (t *simulatedTable) visible(after uint64) []uint64 {
+	var eventIDs []uint64
+	for _, eventID := range t.committed {
+		if eventID > after {
+			eventIDs = append(eventIDs, eventID)
+		}
+	}
+	return eventIDs
+}
+
+type simulatedRelay struct {
+	table   *simulatedTable
+	gaps    *gapTracker
+	now     time.Time
+	cursor  uint64
+	relayed []uint64
+	skipped []gapRange
+}
+
+func newSimulatedRelay(table *simulatedTable, gapTimeout time.Duration) *simulatedRelay {
+	r := &simulatedRelay{
+		table: table,
+		gaps:  newGapTracker(gapTimeout),
+		now:   time.Now(),
+	}
+	r.gaps.now = func() time.Time {
+		return r.now
+	}
+	return r
+}
+
+func (r *simulatedRelay) poll() {
+	eventIDs := r.table.visible(r.cursor)
+	count, _, skipped := r.gaps.relayable(r.cursor, r.table.sequence, eventIDs)
+	r.relayed = append(r.relayed, eventIDs[:count]...)
+	r.skipped = append(r.skipped, skipped...)
+	if count > 0 {
+		r.cursor = eventIDs[count-1]
+	}
+}
+
+func TestGapTracker(t *testing.T) {
+	sequence := Sequence{Increment: 1, Offset: 1}
+
+	t.Run("waits for in-flight transaction", func(t *testing.T) {
+		table := &simulatedTable{sequence: sequence}
+		relay := newSimulatedRelay(table, time.Minute)
+
+		first := table.begin()
+		second := table.begin()
+		table.commit(second)
+
+		relay.poll()
+		assert.Empty(t, relay.relayed)
+
+		table.commit(first)
+		relay.poll()
+		assert.Equal(t, []uint64{first, second}, relay.relayed)
+		assert.Empty(t, relay.skipped)
+	})
+
+	t.Run("stops at gap inside batch", func(t *testing.T) {
+		table := &simulatedTable{sequence: sequence}
+		relay := newSimulatedRelay(table, time.Minute)
+
+		first := table.begin()
+		second := table.begin()
+		third := table.begin()
+		table.commit(first)
+		table.commit(third)
+
+		relay.poll()
+		assert.Equal(t, []uint64{first}, relay.relayed)
+
+		table.commit(second)
+		relay.poll()
+		assert.Equal(t, []uint64{first, second, third}, relay.relayed)
+	})
+
+	t.Run("skips rolled back insert after timeout", func(t *testing.T) {
+		table := &simulatedTable{sequence: sequence}
+		relay := newSimulatedRelay(table, time.Minute)
+
+		_ = table.begin()
+		second := table.begin()
+		table.commit(second)
+
+		relay.poll()
+		relay.now = relay.now.Add(30 * time.Second)
+		relay.poll()
+		assert.Empty(t, relay.relayed)
+
+		relay.now = relay.now.Add(31 * time.Second)
+		relay.poll()
+		assert.Equal(t, []uint64{second}, relay.relayed)
+		assert.Equal(t, []gapRange{{From: 1, To: 1}}, relay.skipped)
+	})
+
+	t.Run("respects auto increment step", func(t *testing.T) {
+		table := &simulatedTable{sequence: Sequence{Increment: 3, Offset: 2}}
+		relay := newSimulatedRelay(table, time.Minute)
+
+		for range 3 {
+			table.commit(table.begin())
+		}
+
+		relay.poll()
+		assert.Equal(t, []uint64{2, 5, 8}, relay.relayed)
+		assert.Empty(t, relay.skipped)
+	})
+
+	t.Run("concurrent transactions never lose committed events", func(t *testing.T) {
+		table := &simulatedTable{sequence: sequence}
+		relay := newSimulatedRelay(table, time.Minute)
+		random := rand.New(rand.NewSource(1))
+
+		var (
+			inFlight  []uint64
+			committed []uint64
+		)
+		for range 1000 {
+			switch {
+			case len(inFlight) == 0 || random.Intn(3) == 0:
+				inFlight = append(inFlight, table.begin())
+			default:
+				i := random.Intn(len(inFlight))
+				eventID := inFlight[i]
+				inFlight = slices.Delete(inFlight, i, i+1)
+				if random.Intn(10) > 0 {
+					table.commit(eventID)
+					committed = append(committed, eventID)
+				}
+			}
+			relay.now = relay.now.Add(10 * time.Millisecond)
+			relay.poll()
+		}
+		for _, eventID := range inFlight {
+			table.commit(eventID)
+			committed = append(committed, eventID)
+		}
+		for range len(committed) {
+			relay.now = relay.now.Add(time.Minute)
+			relay.poll()
+		}
+
+		slices.Sort(committed)
+		assert.Equal(t, committed, relay.relayed)
+	})
+}
@@ -2,19 +2,19 @@ package outbox
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"time"
 
 	"gitea.xscloud.ru/xscloud/golib/pkg/application/logging"
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/outbox"
 	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
 	liberr "gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
 	"gitea.xscloud.ru/xscloud/golib/pkg/internal/helpers"
 )
 
 type Transport interface {
-	HandleEvents(ctx context.Context, correlationID, eventType, payload string) error
+	HandleEvents(ctx context.Context, message Message) error
 }
 
 type Handler interface {
@@ -22,54 +22,97 @@ type Handler interface {
 }
 
 type EventHandlerConfig struct {
+	OutboxName     string
 	TransportName  string
 	Transport      Transport
 	ConnectionPool mysql.ConnectionPool
+	Storage        Storage
+	Locker         Locker
 	Logger         logging.Logger
+	Metrics        outbox.RelayMetrics
+	Notifier       Notifier
 	BatchSize      *uint
+	MaxAttempts    *uint
 	SendInterval   *time.Duration
 	LockTimeout    *time.Duration
+	// GapTimeout is how long the relay waits for a missing event ID before skipping it, 5 seconds by default.
+	// Every rolled back insert leaves a gap, so a longer timeout stalls delivery after each rollback,
+	// while a shorter one may skip events of transactions that commit later and never deliver them
+	GapTimeout *time.Duration
 }
 
-func NewEventHandler(config EventHandlerConfig) Handler {
-	if config.TransportName == "" {
-		panic("transport name cannot be empty")
+func NewEventHandler(config EventHandlerConfig) (Handler, error) {
+	if config.OutboxName == "" {
+		config.OutboxName = config.TransportName
+	}
+	err := validateNames(config.OutboxName, config.TransportName)
+	if err != nil {
+		return nil, err
+	}
+	if config.Storage == nil {
+		config.Storage = NewMySQLStorage(config.OutboxName)
+	}
+	if config.Locker == nil {
+		config.Locker = mysql.NewLocker(config.ConnectionPool)
+	}
+	if config.Metrics == nil {
+		config.Metrics = outbox.NewNopRelayMetrics()
 	}
 	if config.BatchSize == nil {
 		config.BatchSize = helpers.ToPtr(uint(1000))
 	}
+	if config.MaxAttempts == nil {
+		config.MaxAttempts = helpers.ToPtr(uint(0))
+	}
 	if config.SendInterval == nil {
 		config.SendInterval = helpers.ToPtr(10 * time.Second)
 	}
 	if config.LockTimeout == nil {
 		config.LockTimeout = helpers.ToPtr(time.Minute)
 	}
+	if config.GapTimeout == nil {
+		config.GapTimeout = helpers.ToPtr(5 * time.Second)
+	}
 
 	return &handler{
+		outboxName:    config.OutboxName,
 		transportName: config.TransportName,
 		transport:     config.Transport,
 		pool:          config.ConnectionPool,
 		logger:        config.Logger,
+		metrics:       config.Metrics,
+		notifier:      config.Notifier,
 		batchSize:     *config.BatchSize,
+		maxAttempts:   *config.MaxAttempts,
 		sendInterval:  *config.SendInterval,
 		lockTimeout:   *config.LockTimeout,
-		locker:        mysql.NewLocker(config.ConnectionPool),
-	}
+		locker:        config.Locker,
+		storage:       config.Storage,
+		gaps:          newGapTracker(*config.GapTimeout),
+	}, nil
 }
 
 type handler struct {
+	outboxName    string
 	transportName string
 	transport     Transport
 	batchSize     uint
+	maxAttempts   uint
 
-	pool   mysql.ConnectionPool
-	locker mysql.Locker
-	logger logging.Logger
+	pool    mysql.ConnectionPool
+	locker  Locker
+	logger  logging.Logger
+	metrics outbox.RelayMetrics
+	storage Storage
 
+	notifier     Notifier
 	sendInterval time.Duration
 	lockTimeout  time.Duration
+	gaps         *gapTracker
 }
 
+const gapRetryInterval = 200 * time.Millisecond
+
 func (h handler) Start(ctx context.Context) error {
 	needRetry := make(chan bool, 1)
 	defer close(needRetry)
@@ -79,25 +122,54 @@ func (h handler) Start(ctx context.Context) error {
 	default:
 	}
 
+	var dispatched <-chan struct{}
+	if h.notifier != nil {
+		var unsubscribe func()
+		dispatched, unsubscribe = h.notifier.Subscribe(h.outboxName)
+		defer unsubscribe()
+	}
+
+	interval := h.sendInterval
+	wakeup := dispatched
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.After(h.sendInterval):
+		case <-time.After(interval):
 		case <-needRetry:
+		case <-wakeup:
 		}
 
 		sendCtx, cancel := context.WithCancel(context.Background())
-		err := h.sendEvents(sendCtx, needRetry)
+		waitingForGap, err := h.sendEvents(sendCtx, needRetry)
 		cancel()
+		interval = h.sendInterval
+		wakeup = dispatched
+		if errors.Is(err, mysql.ErrLockTimeout) {
+			// another relay holds the lock, wait a full interval instead of retrying on every dispatch
+			h.logger.Warning(err, "outbox handler lock is held, retrying")
+			wakeup = nil
+			continue
+		}
 		if err != nil {
 			return err
 		}
+
+		if waitingForGap {
+			interval = min(gapRetryInterval, h.sendInterval)
+		}
 	}
 }
 
-func (h handler) sendEvents(ctx context.Context, needRetry chan bool) error {
-	return h.locker.ExecuteWithLock(ctx, h.lockName(), h.lockTimeout, func() (err error) {
+func (h handler) sendEvents(ctx context.Context, needRetry chan bool) (waitingForGap bool, err error) {
+	var locked bool
+	err = h.locker.ExecuteWithLock(ctx, handlerLockName(h.outboxName, h.transportName), h.lockTimeout, func() (err error) {
+		locked = true
+		lockedAt := time.Now()
+		defer func() {
+			h.metrics.ObserveLockHoldTime(h.transportName, time.Since(lockedAt))
+		}()
+
 		conn, err := h.pool.TransactionalConnection(ctx)
 		if err != nil {
 			return err
@@ -106,117 +178,152 @@ func (h handler) sendEvents(ctx context.Context, needRetry chan bool) error {
 			err = liberr.Join(err, conn.Close())
 		}()
 
-		lastTrackedEvent, err := h.lastTrackedEvent(ctx, conn)
+		cursor, err := h.storage.Cursor(ctx, conn, h.transportName)
 		if err != nil {
 			return err
 		}
 
-		commitedEvents, err := h.unhandledEvents(ctx, conn, lastTrackedEvent, false)
+		err = h.observeBacklog(ctx, conn, cursor.LastTrackedEventID)
 		if err != nil {
 			return err
 		}
-		if len(commitedEvents) == 0 {
+
+		events, err := h.storage.Events(ctx, conn, cursor.LastTrackedEventID, h.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
 			return nil
 		}
 
-		uncommitedEvents, err := h.unhandledEvents(ctx, conn, lastTrackedEvent, true)
+		sequence, err := h.storage.Sequence(ctx, conn)
 		if err != nil {
 			return err
 		}
 
+		eventIDs := make([]uint64, 0, len(events))
+		for _, event := range events {
+			eventIDs = append(eventIDs, event.EventID)
+		}
+		relayable, waiting, skipped := h.gaps.relayable(cursor.LastTrackedEventID, sequence, eventIDs)
+		for _, gap := range skipped {
+			h.logger.WithFields(logging.Fields{
+				"from_event_id": gap.From,
+				"to_event_id":   gap.To,
+			}).Info("outbox event gap timed out, skipping")
+		}
+		waitingForGap = waiting
+
 		select {
-		case needRetry <- len(uncommitedEvents) > 0 || uint(len(commitedEvents)) == h.batchSize:
+		case needRetry <- !waiting && uint(len(events)) == h.batchSize:
 		default:
 		}
 
-		var handleErr error
-		for i := 0; i < len(commitedEvents); i++ {
-			if uncommitedEvents[i].EventID != commitedEvents[i].EventID {
-				break
-			}
-
-			handleErr = h.transport.HandleEvents(
-				ctx,
-				commitedEvents[i].CorrelationID,
-				commitedEvents[i].EventType,
-				commitedEvents[i].Payload,
-			)
+		var (
+			handleErr error
+			relayed   int
+		)
+		defer func() {
+			h.metrics.ObserveRelayedEvents(h.transportName, relayed)
+		}()
+		for _, event := range events[:relayable] {
+			handleErr = h.transport.HandleEvents(ctx, event)
 			if handleErr != nil {
+				h.metrics.IncTransportErrors(h.transportName)
 				h.logger.Error(handleErr)
-				break
+
+				var parked bool
+				parked, err = h.handleFailure(ctx, conn, cursor, event, handleErr)
+				if err != nil {
+					return err
+				}
+				if !parked {
+					break
+				}
+				cursor = Cursor{LastTrackedEventID: event.EventID}
+				continue
 			}
 
-			err = h.trackLastHandledEvent(ctx, conn, commitedEvents[i].EventID)
+			err = h.storage.TrackEvent(ctx, conn, h.transportName, event.EventID)
 			if err != nil {
 				return err
 			}
+			cursor = Cursor{LastTrackedEventID: event.EventID}
+			relayed++
 		}
 
 		return nil
 	})
-}
-
-func (h handler) lastTrackedEvent(ctx context.Context, client mysql.ClientContext) (uint64, error) {
-	var lastEventID uint64
-	err := client.GetContext(ctx, &lastEventID, fmt.Sprintf(`
-		SELECT last_tracked_event_id FROM outbox_%s_tracked_event WHERE transport_name = ?
-	`, h.transportName), h.transportName)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return 0, nil
-		}
-		return 0, err
+	if err != nil && !locked {
+		h.metrics.IncLockFailures(h.transportName)
 	}
-	return lastEventID, nil
+	return waitingForGap, err
 }
 
-func (h handler) unhandledEvents(ctx context.Context, conn mysql.TransactionalConnection, lastTracked uint64, includeUncommited bool) ([]storedEvent, error) {
-	var (
-		client mysql.ClientContext = conn
-		err    error
-	)
-	if includeUncommited {
-		tx, err := conn.BeginTransaction(ctx, &sql.TxOptions{
-			Isolation: sql.LevelReadUncommitted,
-			ReadOnly:  true,
+func (h handler) handleFailure(
+	ctx context.Context,
+	conn mysql.TransactionalConnection,
+	cursor Cursor,
+	event Message,
+	handleErr error,
+) (parked bool, err error) {
+	attempts := uint(1)
+	if cursor.FailedEventID == event.EventID {
+		attempts = cursor.FailedAttempts + 1
+	}
+	if h.maxAttempts == 0 || attempts < h.maxAttempts {
+		return false, h.storage.TrackFailedEvent(ctx, conn, h.transportName, Cursor{
+			LastTrackedEventID: cursor.LastTrackedEventID,
+			FailedEventID:      event.EventID,
+			FailedAttempts:     attempts,
 		})
+	}
+
+	tx, err := conn.BeginTransaction(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
 		if err != nil {
-			return nil, err
-		}
-		defer func() {
 			err = liberr.Join(err, tx.Rollback())
-		}()
-		client = tx
-	}
-
-	var events []storedEvent
-	err = client.SelectContext(ctx, &events, fmt.Sprintf(`
-		SELECT 
-		    event_id,
-		    correlation_id,
-		    event_type,
-		    payload
-		FROM outbox_%s_event
-		WHERE event_id > ?
-		LIMIT %v
-	`, h.transportName, h.batchSize), lastTracked)
+		}
+	}()
+
+	err = h.storage.ParkEvent(ctx, tx, h.transportName, ParkedEvent{
+		Message:   event,
+		Attempts:  attempts,
+		LastError: handleErr.Error(),
+		ParkedAt:  time.Now(),
+	})
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	err = h.storage.TrackEvent(ctx, tx, h.transportName, event.EventID)
+	if err != nil {
+		return false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, err
 	}
 
-	return events, nil
+	h.logger.WithField("event_id", event.EventID).Warning(handleErr, "outbox event parked")
+	return true, nil
 }
 
-func (h handler) trackLastHandledEvent(ctx context.Context, client mysql.ClientContext, lastHandledEvent uint64) error {
-	_, err := client.ExecContext(ctx, fmt.Sprintf(`
-		INSERT INTO outbox_%s_tracked_event (transport_name, last_tracked_event_id) VALUES (?, ?)
-		ON DUPLICATE KEY UPDATE
-			transport_name = VALUES(transport_name),
-			last_tracked_event_id = VALUES(last_tracked_event_id)
-	`, h.transportName), h.transportName, lastHandledEvent)
-	return err
+func (h handler) observeBacklog(ctx context.Context, client mysql.ClientContext, lastTracked uint64) error {
+	b, err := backlog(ctx, h.storage, client, lastTracked)
+	if err != nil {
+		return err
+	}
+	h.metrics.SetBacklog(h.transportName, b.Size)
+	h.metrics.SetOldestEventAge(h.transportName, b.OldestEventAge)
+	return nil
 }
 
-func (h handler) lockName() string {
-	return fmt.Sprintf("outbox_%s_handler", h.transportName)
+func handlerLockName(outboxName, transportName string) string {
+	if outboxName == transportName {
+		return fmt.Sprintf("outbox_%s_handler", transportName)
+	}
+	return fmt.Sprintf("outbox_%s_%s_handler", outboxName, transportName)
 }
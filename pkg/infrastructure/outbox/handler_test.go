@@ -0,0 +1,177 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/outbox"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/logging"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/helpers"
+)
+
+type recordingMetrics struct {
+	outbox.RelayMetrics
+
+	mu           sync.Mutex
+	lockFailures int
+	relayed      int
+}
+
+func (m *recordingMetrics) IncLockFailures(string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lockFailures++
+}
+
+func (m *recordingMetrics) ObserveRelayedEvents(_ string, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.relayed += count
+}
+
+func (m *recordingMetrics) counts() (lockFailures, relayed int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lockFailures, m.relayed
+}
+
+type contendedLocker struct {
+	Locker
+	mu       sync.Mutex
+	timeouts int
+}
+
+func (l *contendedLocker) ExecuteWithLock(ctx context.Context, lockName string, lockTimeout time.Duration, callback func() error) error {
+	l.mu.Lock()
+	if l.timeouts > 0 {
+		l.timeouts--
+		l.mu.Unlock()
+		return mysql.ErrLockTimeout
+	}
+	l.mu.Unlock()
+	return l.Locker.ExecuteWithLock(ctx, lockName, lockTimeout, callback)
+}
+
+func TestHandlerStart(t *testing.T) {
+	t.Run("retries after lock timeout", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		o.dispatch(t, t.Context(), "first")
+		metrics := &recordingMetrics{RelayMetrics: outbox.NewNopRelayMetrics()}
+		h, err := NewEventHandler(EventHandlerConfig{
+			TransportName:  testTransportName,
+			Transport:      o.transport,
+			ConnectionPool: o.pool,
+			Storage:        NewSQLiteStorage(testTransportName),
+			Locker:         &contendedLocker{Locker: o.locker, timeouts: 2},
+			Logger:         logging.NewJSONLogger(&logging.Config{AppName: "test"}),
+			Metrics:        metrics,
+			SendInterval:   helpers.ToPtr(10 * time.Millisecond),
+		})
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		done := make(chan error, 1)
+		go func() {
+			done <- h.Start(ctx)
+		}()
+		assert.Eventually(t, func() bool {
+			_, relayed := metrics.counts()
+			return relayed == 1
+		}, time.Second, 5*time.Millisecond)
+		cancel()
+
+		require.NoError(t, <-done)
+		lockFailures, _ := metrics.counts()
+		assert.Equal(t, 2, lockFailures)
+		assert.Equal(t, []string{"first"}, o.transport.eventTypes())
+	})
+}
+
+func TestHandlerParking(t *testing.T) {
+	poison := func(message Message) error {
+		if message.EventType == "poison" {
+			return errors.New("poison event")
+		}
+		return nil
+	}
+
+	t.Run("parks event after max attempts", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		o.dispatch(t, t.Context(), "poison", "next")
+		o.transport.fail = poison
+		h := o.handler(t, 3)
+
+		for attempt := uint(1); attempt < 3; attempt++ {
+			o.sendEvents(t, h)
+			assert.Empty(t, o.transport.messages)
+
+			conn, err := o.pool.TransactionalConnection(t.Context())
+			require.NoError(t, err)
+			cursor, err := h.storage.Cursor(t.Context(), conn, testTransportName)
+			require.NoError(t, conn.Close())
+			require.NoError(t, err)
+			assert.Equal(t, attempt, cursor.FailedAttempts)
+		}
+
+		o.sendEvents(t, h)
+		assert.Equal(t, []string{"next"}, o.transport.eventTypes())
+		var parked []string
+		require.NoError(t, o.db.Select(&parked, `SELECT event_type FROM outbox_test_parked_event`))
+		assert.Equal(t, []string{"poison"}, parked)
+	})
+
+	t.Run("retries forever without max attempts", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		o.dispatch(t, t.Context(), "poison", "next")
+		o.transport.fail = poison
+		h := o.handler(t, 0)
+
+		for range 5 {
+			o.sendEvents(t, h)
+		}
+		assert.Empty(t, o.transport.messages)
+		var parked int
+		require.NoError(t, o.db.Get(&parked, `SELECT COUNT(*) FROM outbox_test_parked_event`))
+		assert.Zero(t, parked)
+	})
+}
+
+func TestHandlerGaps(t *testing.T) {
+	o := newSQLiteOutbox(t)
+	o.dispatch(t, t.Context(), "first", "in flight", "third")
+	_, err := o.db.Exec(`DELETE FROM outbox_test_event WHERE event_type = 'in flight'`)
+	require.NoError(t, err)
+
+	h, err := NewEventHandler(EventHandlerConfig{
+		TransportName:  testTransportName,
+		Transport:      o.transport,
+		ConnectionPool: o.pool,
+		Storage:        NewSQLiteStorage(testTransportName),
+		Locker:         o.locker,
+		Logger:         logging.NewJSONLogger(&logging.Config{AppName: "test"}),
+		GapTimeout:     helpers.ToPtr(time.Minute),
+	})
+	require.NoError(t, err)
+	now := time.Now()
+	h.(*handler).gaps.now = func() time.Time {
+		return now
+	}
+
+	waiting, err := h.(*handler).sendEvents(t.Context(), make(chan bool, 1))
+	require.NoError(t, err)
+	assert.True(t, waiting)
+	assert.Equal(t, []string{"first"}, o.transport.eventTypes())
+
+	now = now.Add(2 * time.Minute)
+	waiting, err = h.(*handler).sendEvents(t.Context(), make(chan bool, 1))
+	require.NoError(t, err)
+	assert.False(t, waiting)
+	assert.Equal(t, []string{"first", "third"}, o.transport.eventTypes())
+}
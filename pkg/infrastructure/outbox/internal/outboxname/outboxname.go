@@ -0,0 +1,17 @@
+package outboxname
+
+import (
+	"fmt"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/identifier"
+)
+
+const longestTableAffix = "outbox__tracked_event"
+
+func Validate(outboxName string) error {
+	err := identifier.Validate(outboxName, identifier.MaxLength-len(longestTableAffix))
+	if err != nil {
+		return fmt.Errorf("outbox name: %w", err)
+	}
+	return nil
+}
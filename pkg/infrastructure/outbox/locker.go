@@ -0,0 +1,111 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	liberr "gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
+)
+
+type Locker interface {
+	ExecuteWithLock(ctx context.Context, lockName string, lockTimeout time.Duration, callback func() error) error
+}
+
+func NewPostgreSQLLocker(pool mysql.ConnectionPool) Locker {
+	return &postgresLocker{pool: pool}
+}
+
+type postgresLocker struct {
+	pool mysql.ConnectionPool
+}
+
+const advisoryLockRetryInterval = 100 * time.Millisecond
+
+func (l postgresLocker) ExecuteWithLock(ctx context.Context, lockName string, lockTimeout time.Duration, callback func() error) (err error) {
+	conn, err := l.pool.TransactionalConnection(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = liberr.Join(err, conn.Close())
+	}()
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		var acquired bool
+		err = conn.GetContext(ctx, &acquired, "SELECT pg_try_advisory_lock(hashtext($1))", lockName)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			return mysql.ErrLockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(advisoryLockRetryInterval):
+		}
+	}
+	defer func() {
+		var released bool
+		unlockErr := conn.GetContext(ctx, &released, "SELECT pg_advisory_unlock(hashtext($1))", lockName)
+		if unlockErr == nil && !released {
+			unlockErr = mysql.ErrLockNotLocked
+		}
+		err = liberr.Join(err, unlockErr)
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = liberr.Join(err, fmt.Errorf("panic: %v", r))
+			panic(r)
+		}
+	}()
+
+	return callback()
+}
+
+func NewLocalLocker() Locker {
+	return &localLocker{
+		locks: make(map[string]chan struct{}),
+	}
+}
+
+type localLocker struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func (l *localLocker) ExecuteWithLock(ctx context.Context, lockName string, lockTimeout time.Duration, callback func() error) error {
+	lock := l.lock(lockName)
+	select {
+	case lock <- struct{}{}:
+	case <-time.After(lockTimeout):
+		return mysql.ErrLockTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() {
+		<-lock
+	}()
+
+	return callback()
+}
+
+func (l *localLocker) lock(lockName string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock, ok := l.locks[lockName]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		l.locks[lockName] = lock
+	}
+	return lock
+}
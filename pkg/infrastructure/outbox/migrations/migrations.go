@@ -9,16 +9,19 @@ import (
 	"gitea.xscloud.ru/xscloud/golib/pkg/common/io"
 	libmigrator "gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/migrator"
 	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/outbox/internal/outboxname"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/identifier"
 )
 
 func NewOutboxMigrator(
 	ctx context.Context,
 	pool mysql.ConnectionPool,
 	logger logging.Logger,
-	transportName string,
+	outboxName string,
 ) (migrator libmigrator.Migrator, release io.CloserFunc, err error) {
-	if transportName == "" {
-		panic("transportName cannot be empty")
+	err = outboxname.Validate(outboxName)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	conn, err2 := pool.TransactionalConnection(ctx)
@@ -31,14 +34,14 @@ func NewOutboxMigrator(
 		}
 	}()
 
-	tablePrefix := fmt.Sprintf("outbox_%s", transportName)
+	tablePrefix := fmt.Sprintf("outbox_%s", outboxName)
 
 	l := logger.WithField("migrator", tablePrefix)
 	factory := libmigrator.NewMigratorFactory(tablePrefix, conn, l)
 
 	migrations := make([]libmigrator.Migration, 0, len(builderFunctions))
 	for _, builder := range builderFunctions {
-		migrations = append(migrations, builder(conn, transportName))
+		migrations = append(migrations, builder(conn, outboxName))
 	}
 
 	migrator, err = factory.NewMigrator(ctx, migrations...)
@@ -51,4 +54,28 @@ func NewOutboxMigrator(
 var builderFunctions = []func(client mysql.ClientContext, transport string) libmigrator.Migration{
 	newVersion1762198457,
 	newVersion1762551106,
+	newVersion1762905600,
+	newVersion1763078400,
+	newVersion1763164800,
+	newVersion1763251200,
+	newVersion1763337600,
+}
+
+var ErrEncodedPayloads = errors.New("table has compressed payloads that text column cannot hold")
+
+// checkNoEncodedPayloads guards reverting payloads to TEXT, compressed payloads would be corrupted by the conversion
+func checkNoEncodedPayloads(ctx context.Context, client mysql.ClientContext, tableName string) error {
+	var encoded bool
+	err := client.GetContext(ctx, &encoded, fmt.Sprintf(`SELECT EXISTS(SELECT * FROM %s WHERE payload_encoding <> '')`, tableName))
+	if err != nil {
+		return err
+	}
+	if encoded {
+		return fmt.Errorf("%w: %s, relay or delete them before rolling back", ErrEncodedPayloads, tableName)
+	}
+	return nil
+}
+
+func mysqlTableName(outboxName, table string) string {
+	return identifier.QuoteMySQL(fmt.Sprintf("outbox_%s_%s", outboxName, table))
 }
@@ -0,0 +1,44 @@
+package outboxmigrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/migrator"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/sqltest"
+)
+
+func encodedPayloadsClient(encoded bool) *sqltest.RecordingClient {
+	return &sqltest.RecordingClient{Get: func(dest interface{}, _ string) error {
+		*dest.(*bool) = encoded
+		return nil
+	}}
+}
+
+func TestPayloadEncodingDown(t *testing.T) {
+	builders := map[string]func(client *sqltest.RecordingClient) migrator.Migration{
+		"event":        func(client *sqltest.RecordingClient) migrator.Migration { return newVersion1763251200(client, "test") },
+		"parked event": func(client *sqltest.RecordingClient) migrator.Migration { return newVersion1763337600(client, "test") },
+	}
+	for table, builder := range builders {
+		t.Run(table+" refuses while compressed payloads exist", func(t *testing.T) {
+			client := encodedPayloadsClient(true)
+			migration, ok := builder(client).(migrator.ReversibleMigration)
+			require.True(t, ok)
+
+			assert.ErrorIs(t, migration.Down(t.Context()), ErrEncodedPayloads)
+			assert.Empty(t, client.Execs)
+		})
+
+		t.Run(table+" reverts without compressed payloads", func(t *testing.T) {
+			client := encodedPayloadsClient(false)
+			migration, ok := builder(client).(migrator.ReversibleMigration)
+			require.True(t, ok)
+
+			require.NoError(t, migration.Down(t.Context()))
+			assert.Len(t, client.Execs, 1)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package outboxmigrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/outbox/internal/outboxname"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/identifier"
+)
+
+var schemaTables = []string{"event", "tracked_event", "parked_event"}
+
+func CreatePostgreSQLSchema(ctx context.Context, client mysql.ClientContext, outboxName string) error {
+	return createSchema(ctx, client, outboxName, []string{
+		`
+		CREATE TABLE IF NOT EXISTS %s
+		(
+		    event_id         BIGINT          GENERATED BY DEFAULT AS IDENTITY,
+		    correlation_id   VARCHAR(128)    NOT NULL,
+		    event_type       VARCHAR(128)    NOT NULL,
+		    payload          BYTEA           NOT NULL,
+		    payload_encoding VARCHAR(16)     NOT NULL DEFAULT '',
+		    headers          JSONB           NULL,
+		    schema_version   INTEGER         NOT NULL DEFAULT 0,
+		    created_at       TIMESTAMPTZ     NOT NULL DEFAULT now(),
+		    PRIMARY KEY (event_id)
+		)
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS %s
+		(
+		    transport_name          VARCHAR(128)    NOT NULL,
+		    last_tracked_event_id   BIGINT          NOT NULL,
+		    failed_event_id         BIGINT          NULL,
+		    failed_attempts         INTEGER         NOT NULL DEFAULT 0,
+		    PRIMARY KEY (transport_name)
+		)
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS %s
+		(
+		    transport_name   VARCHAR(128)    NOT NULL,
+		    event_id         BIGINT          NOT NULL,
+		    correlation_id   VARCHAR(128)    NOT NULL,
+		    event_type       VARCHAR(128)    NOT NULL,
+		    payload          BYTEA           NOT NULL,
+		    payload_encoding VARCHAR(16)     NOT NULL DEFAULT '',
+		    headers          JSONB           NULL,
+		    schema_version   INTEGER         NOT NULL,
+		    created_at       TIMESTAMPTZ     NOT NULL,
+		    attempts         INTEGER         NOT NULL,
+		    last_error       TEXT            NOT NULL,
+		    parked_at        TIMESTAMPTZ     NOT NULL,
+		    PRIMARY KEY (transport_name, event_id)
+		)
+		`,
+	})
+}
+
+func CreateSQLiteSchema(ctx context.Context, client mysql.ClientContext, outboxName string) error {
+	return createSchema(ctx, client, outboxName, []string{
+		`
+		CREATE TABLE IF NOT EXISTS %s
+		(
+		    event_id         INTEGER     NOT NULL PRIMARY KEY AUTOINCREMENT,
+		    correlation_id   TEXT        NOT NULL,
+		    event_type       TEXT        NOT NULL,
+		    payload          BLOB        NOT NULL,
+		    payload_encoding TEXT        NOT NULL DEFAULT '',
+		    headers          TEXT        NULL,
+		    schema_version   INTEGER     NOT NULL DEFAULT 0,
+		    created_at       DATETIME    NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS %s
+		(
+		    transport_name          TEXT        NOT NULL PRIMARY KEY,
+		    last_tracked_event_id   INTEGER     NOT NULL,
+		    failed_event_id         INTEGER     NULL,
+		    failed_attempts         INTEGER     NOT NULL DEFAULT 0
+		)
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS %s
+		(
+		    transport_name   TEXT        NOT NULL,
+		    event_id         INTEGER     NOT NULL,
+		    correlation_id   TEXT        NOT NULL,
+		    event_type       TEXT        NOT NULL,
+		    payload          BLOB        NOT NULL,
+		    payload_encoding TEXT        NOT NULL DEFAULT '',
+		    headers          TEXT        NULL,
+		    schema_version   INTEGER     NOT NULL,
+		    created_at       DATETIME    NOT NULL,
+		    attempts         INTEGER     NOT NULL,
+		    last_error       TEXT        NOT NULL,
+		    parked_at        DATETIME    NOT NULL,
+		    PRIMARY KEY (transport_name, event_id)
+		)
+		`,
+	})
+}
+
+func createSchema(ctx context.Context, client mysql.ClientContext, outboxName string, queries []string) error {
+	err := outboxname.Validate(outboxName)
+	if err != nil {
+		return err
+	}
+
+	for i, query := range queries {
+		tableName := identifier.QuoteANSI(fmt.Sprintf("outbox_%s_%s", outboxName, schemaTables[i]))
+		_, err = client.ExecContext(ctx, fmt.Sprintf(query, tableName))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
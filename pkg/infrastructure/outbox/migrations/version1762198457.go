@@ -32,7 +32,7 @@ func (v version1762198457) Description() string {
 
 func (v version1762198457) Up(ctx context.Context) error {
 	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
-		CREATE TABLE outbox_%s_event
+		CREATE TABLE %s
 		(
 		    event_id         BIGINT          NOT NULL AUTO_INCREMENT,
 		    correlation_id   VARBINARY(128)  NOT NULL,
@@ -43,6 +43,13 @@ func (v version1762198457) Up(ctx context.Context) error {
 		    ENGINE = InnoDB
 		    CHARACTER SET = utf8mb4
 		    COLLATE utf8mb4_unicode_ci
-	`, v.transport))
+	`, mysqlTableName(v.transport, "event")))
+	return errors.WithStack(err)
+}
+
+func (v version1762198457) Down(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
+		DROP TABLE %s
+	`, mysqlTableName(v.transport, "event")))
 	return errors.WithStack(err)
 }
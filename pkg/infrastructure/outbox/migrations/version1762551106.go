@@ -32,7 +32,7 @@ func (v version1762551106) Description() string {
 
 func (v version1762551106) Up(ctx context.Context) error {
 	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
-		CREATE TABLE outbox_%s_tracked_event
+		CREATE TABLE %s
 		(
 		    transport_name          VARBINARY(128)  NOT NULL,
 		    last_tracked_event_id   BIGINT          NOT NULL,
@@ -41,6 +41,13 @@ func (v version1762551106) Up(ctx context.Context) error {
 		    ENGINE = InnoDB
 		    CHARACTER SET = utf8mb4
 		    COLLATE utf8mb4_unicode_ci
-	`, v.transport))
+	`, mysqlTableName(v.transport, "tracked_event")))
+	return errors.WithStack(err)
+}
+
+func (v version1762551106) Down(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
+		DROP TABLE %s
+	`, mysqlTableName(v.transport, "tracked_event")))
 	return errors.WithStack(err)
 }
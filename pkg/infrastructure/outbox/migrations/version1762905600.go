@@ -0,0 +1,51 @@
+package outboxmigrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/migrator"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+)
+
+func newVersion1762905600(client mysql.ClientContext, transport string) migrator.Migration {
+	return &version1762905600{
+		client:    client,
+		transport: transport,
+	}
+}
+
+type version1762905600 struct {
+	client    mysql.ClientContext
+	transport string
+}
+
+func (v version1762905600) Version() int64 {
+	return 1762905600
+}
+
+func (v version1762905600) Description() string {
+	return fmt.Sprintf("Add metadata columns to 'outbox_%s_event' table", v.transport)
+}
+
+func (v version1762905600) Up(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
+		ALTER TABLE %s
+		    ADD COLUMN created_at       DATETIME(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+		    ADD COLUMN headers          JSON            NULL,
+		    ADD COLUMN schema_version   INT UNSIGNED    NOT NULL DEFAULT 0
+	`, mysqlTableName(v.transport, "event")))
+	return errors.WithStack(err)
+}
+
+func (v version1762905600) Down(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
+		ALTER TABLE %s
+		    DROP COLUMN created_at,
+		    DROP COLUMN headers,
+		    DROP COLUMN schema_version
+	`, mysqlTableName(v.transport, "event")))
+	return errors.WithStack(err)
+}
@@ -0,0 +1,49 @@
+package outboxmigrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/migrator"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+)
+
+func newVersion1763078400(client mysql.ClientContext, transport string) migrator.Migration {
+	return &version1763078400{
+		client:    client,
+		transport: transport,
+	}
+}
+
+type version1763078400 struct {
+	client    mysql.ClientContext
+	transport string
+}
+
+func (v version1763078400) Version() int64 {
+	return 1763078400
+}
+
+func (v version1763078400) Description() string {
+	return fmt.Sprintf("Add failed attempts columns to 'outbox_%s_tracked_event' table", v.transport)
+}
+
+func (v version1763078400) Up(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
+		ALTER TABLE %s
+		    ADD COLUMN failed_event_id  BIGINT          NULL,
+		    ADD COLUMN failed_attempts  INT UNSIGNED    NOT NULL DEFAULT 0
+	`, mysqlTableName(v.transport, "tracked_event")))
+	return errors.WithStack(err)
+}
+
+func (v version1763078400) Down(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
+		ALTER TABLE %s
+		    DROP COLUMN failed_event_id,
+		    DROP COLUMN failed_attempts
+	`, mysqlTableName(v.transport, "tracked_event")))
+	return errors.WithStack(err)
+}
@@ -0,0 +1,62 @@
+package outboxmigrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/migrator"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+)
+
+func newVersion1763164800(client mysql.ClientContext, transport string) migrator.Migration {
+	return &version1763164800{
+		client:    client,
+		transport: transport,
+	}
+}
+
+type version1763164800 struct {
+	client    mysql.ClientContext
+	transport string
+}
+
+func (v version1763164800) Version() int64 {
+	return 1763164800
+}
+
+func (v version1763164800) Description() string {
+	return fmt.Sprintf("Create 'outbox_%s_parked_event' table", v.transport)
+}
+
+func (v version1763164800) Up(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE %s
+		(
+		    transport_name   VARBINARY(128)  NOT NULL,
+		    event_id         BIGINT          NOT NULL,
+		    correlation_id   VARBINARY(128)  NOT NULL,
+		    event_type       VARBINARY(128)  NOT NULL,
+		    payload          TEXT            NOT NULL,
+		    headers          JSON            NULL,
+		    schema_version   INT UNSIGNED    NOT NULL,
+		    created_at       DATETIME(6)     NOT NULL,
+		    attempts         INT UNSIGNED    NOT NULL,
+		    last_error       TEXT            NOT NULL,
+		    parked_at        DATETIME(6)     NOT NULL,
+		    PRIMARY KEY (transport_name, event_id)
+		) 
+		    ENGINE = InnoDB
+		    CHARACTER SET = utf8mb4
+		    COLLATE utf8mb4_unicode_ci
+	`, mysqlTableName(v.transport, "parked_event")))
+	return errors.WithStack(err)
+}
+
+func (v version1763164800) Down(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
+		DROP TABLE %s
+	`, mysqlTableName(v.transport, "parked_event")))
+	return errors.WithStack(err)
+}
@@ -0,0 +1,54 @@
+package outboxmigrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/migrator"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+)
+
+func newVersion1763251200(client mysql.ClientContext, transport string) migrator.Migration {
+	return &version1763251200{
+		client:    client,
+		transport: transport,
+	}
+}
+
+type version1763251200 struct {
+	client    mysql.ClientContext
+	transport string
+}
+
+func (v version1763251200) Version() int64 {
+	return 1763251200
+}
+
+func (v version1763251200) Description() string {
+	return fmt.Sprintf("Store 'outbox_%s_event' payload as binary with encoding", v.transport)
+}
+
+func (v version1763251200) Up(ctx context.Context) error {
+	_, err := v.client.ExecContext(ctx, fmt.Sprintf(`
+		ALTER TABLE %s
+		    MODIFY COLUMN payload LONGBLOB NOT NULL,
+		    ADD COLUMN payload_encoding VARBINARY(16) NOT NULL DEFAULT '' AFTER payload
+	`, mysqlTableName(v.transport, "event")))
+	return errors.WithStack(err)
+}
+
+func (v version1763251200) Down(ctx context.Context) error {
+	tableName := mysqlTableName(v.transport, "event")
+	err := checkNoEncodedPayloads(ctx, v.client, tableName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = v.client.ExecContext(ctx, fmt.Sprintf(`
+		ALTER TABLE %s
+		    DROP COLUMN payload_encoding,
+		    MODIFY COLUMN payload TEXT NOT NULL
+	`, tableName))
+	return errors.WithStack(err)
+}
@@ -0,0 +1,91 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/outbox"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/logging"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	outboxmigrations "gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/outbox/migrations"
+)
+
+const mysqlTestDSNEnv = "OUTBOX_TEST_MYSQL_DSN"
+
+// TestMySQLOutbox runs against a real server, the DSN is used without parseTime as services usually pass it
+func TestMySQLOutbox(t *testing.T) {
+	dsn := os.Getenv(mysqlTestDSNEnv)
+	if dsn == "" {
+		t.Skipf("set %s to run against MySQL", mysqlTestDSNEnv)
+	}
+	cfg, err := driver.ParseDSN(dsn)
+	require.NoError(t, err)
+	cfg.ParseTime = false
+
+	connector := mysql.NewConnector()
+	require.NoError(t, connector.Open(cfg.FormatDSN(), mysql.Config{MaxConnections: 10}))
+	t.Cleanup(func() {
+		_ = connector.Close()
+	})
+	client := connector.TransactionalClient()
+	pool := mysql.NewConnectionPool(client)
+	logger := logging.NewJSONLogger(&logging.Config{AppName: "test"})
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	outboxName := "test_" + hex.EncodeToString(suffix)
+	t.Cleanup(func() {
+		for _, table := range []string{"event", "tracked_event", "parked_event", "migrations"} {
+			_, _ = client.ExecContext(context.Background(), fmt.Sprintf("DROP TABLE IF EXISTS outbox_%s_%s", outboxName, table))
+		}
+	})
+
+	migrator, release, err := outboxmigrations.NewOutboxMigrator(t.Context(), pool, logger, outboxName)
+	require.NoError(t, err)
+	require.NoError(t, migrator.Migrate())
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	require.NotEmpty(t, statuses)
+	assert.False(t, statuses[0].AppliedAt.IsZero())
+	require.NoError(t, release())
+
+	uow := mysql.NewUnitOfWork(pool, func(client mysql.ClientContext) mysql.ClientContext {
+		return client
+	})
+	dispatcher, err := NewEventDispatcher[testEvent]("app", outboxName, outbox.NewJSONEventSerializer[testEvent](), uow)
+	require.NoError(t, err)
+	ctx := outbox.WithHeader(t.Context(), "traceparent", "trace")
+	require.NoError(t, dispatcher.Dispatch(ctx, testEvent{EventType: "created", Value: "created"}))
+
+	transport := &recordingTransport{}
+	admin, err := NewAdministrator(AdministratorConfig{OutboxName: outboxName, TransportName: testTransportName, ConnectionPool: pool})
+	require.NoError(t, err)
+	backlog, err := admin.Backlog(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), backlog.Size)
+	assert.Less(t, backlog.OldestEventAge, time.Minute)
+
+	h, err := NewEventHandler(EventHandlerConfig{
+		OutboxName:     outboxName,
+		TransportName:  testTransportName,
+		Transport:      transport,
+		ConnectionPool: pool,
+		Logger:         logger,
+	})
+	require.NoError(t, err)
+	_, err = h.(*handler).sendEvents(t.Context(), make(chan bool, 1))
+	require.NoError(t, err)
+
+	require.Len(t, transport.messages, 1)
+	assert.False(t, transport.messages[0].CreatedAt.IsZero())
+	assert.Equal(t, map[string]string{"traceparent": "trace"}, transport.messages[0].Headers)
+}
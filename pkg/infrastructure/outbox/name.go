@@ -0,0 +1,28 @@
+package outbox
+
+import (
+	"fmt"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/outbox/internal/outboxname"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/identifier"
+)
+
+var ErrInvalidName = identifier.ErrInvalid
+
+const maxTransportNameLength = 128
+
+func validateTransportName(transportName string) error {
+	err := identifier.Validate(transportName, maxTransportNameLength)
+	if err != nil {
+		return fmt.Errorf("transport name: %w", err)
+	}
+	return nil
+}
+
+func validateNames(outboxName, transportName string) error {
+	err := validateTransportName(transportName)
+	if err != nil {
+		return err
+	}
+	return outboxname.Validate(outboxName)
+}
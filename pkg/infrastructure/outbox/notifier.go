@@ -0,0 +1,60 @@
+package outbox
+
+import (
+	"slices"
+	"sync"
+)
+
+type Notifier interface {
+	Notify(outboxName string)
+	// Subscribe returns a channel woken by Notify and a func that stops the subscription
+	Subscribe(outboxName string) (<-chan struct{}, func())
+}
+
+func NewNotifier() Notifier {
+	return &notifier{
+		subscribers: make(map[string][]chan struct{}),
+	}
+}
+
+type notifier struct {
+	mu          sync.Mutex
+	subscribers map[string][]chan struct{}
+}
+
+func (n *notifier) Notify(outboxName string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, ch := range n.subscribers[outboxName] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *notifier) Subscribe(outboxName string) (<-chan struct{}, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	n.subscribers[outboxName] = append(n.subscribers[outboxName], ch)
+	return ch, func() {
+		n.unsubscribe(outboxName, ch)
+	}
+}
+
+func (n *notifier) unsubscribe(outboxName string, ch chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	subscribers := slices.DeleteFunc(n.subscribers[outboxName], func(subscriber chan struct{}) bool {
+		return subscriber == ch
+	})
+	if len(subscribers) == 0 {
+		delete(n.subscribers, outboxName)
+		return
+	}
+	n.subscribers[outboxName] = subscribers
+}
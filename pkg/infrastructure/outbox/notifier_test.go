@@ -0,0 +1,27 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifier(t *testing.T) {
+	n := NewNotifier()
+	first, unsubscribeFirst := n.Subscribe("orders")
+	second, unsubscribeSecond := n.Subscribe("orders")
+
+	n.Notify("orders")
+	assert.Len(t, first, 1)
+	assert.Len(t, second, 1)
+	<-first
+	<-second
+
+	unsubscribeFirst()
+	n.Notify("orders")
+	assert.Empty(t, first)
+	assert.Len(t, second, 1)
+
+	unsubscribeSecond()
+	assert.Empty(t, n.(*notifier).subscribers)
+}
@@ -0,0 +1,161 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	liberr "gitea.xscloud.ru/xscloud/golib/pkg/internal/errors"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/helpers"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/sqltime"
+)
+
+var ErrParkedEventNotFound = errors.New("parked event not found")
+
+type ParkedEvent struct {
+	Message
+	Attempts  uint
+	LastError string
+	ParkedAt  time.Time
+}
+
+type ParkedEvents interface {
+	List(ctx context.Context, afterEventID uint64, limit uint) ([]ParkedEvent, error)
+	Replay(ctx context.Context, eventID uint64) error
+	Discard(ctx context.Context, eventID uint64) error
+}
+
+type ParkedEventsConfig struct {
+	OutboxName     string
+	TransportName  string
+	Transport      Transport
+	ConnectionPool mysql.ConnectionPool
+	Storage        Storage
+	Locker         Locker
+	LockTimeout    *time.Duration
+}
+
+func NewParkedEvents(config ParkedEventsConfig) (ParkedEvents, error) {
+	if config.OutboxName == "" {
+		config.OutboxName = config.TransportName
+	}
+	err := validateNames(config.OutboxName, config.TransportName)
+	if err != nil {
+		return nil, err
+	}
+	if config.Storage == nil {
+		config.Storage = NewMySQLStorage(config.OutboxName)
+	}
+	if config.Locker == nil {
+		config.Locker = mysql.NewLocker(config.ConnectionPool)
+	}
+	if config.LockTimeout == nil {
+		config.LockTimeout = helpers.ToPtr(time.Minute)
+	}
+
+	return &parkedEvents{
+		outboxName:    config.OutboxName,
+		transportName: config.TransportName,
+		transport:     config.Transport,
+		pool:          config.ConnectionPool,
+		locker:        config.Locker,
+		lockTimeout:   *config.LockTimeout,
+		storage:       config.Storage,
+	}, nil
+}
+
+type parkedEvents struct {
+	outboxName    string
+	transportName string
+	transport     Transport
+
+	pool        mysql.ConnectionPool
+	locker      Locker
+	lockTimeout time.Duration
+	storage     ParkedEventStorage
+}
+
+func (p parkedEvents) List(ctx context.Context, afterEventID uint64, limit uint) (_ []ParkedEvent, err error) {
+	conn, err := p.pool.TransactionalConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = liberr.Join(err, conn.Close())
+	}()
+
+	return p.storage.ParkedEvents(ctx, conn, p.transportName, afterEventID, limit)
+}
+
+func (p parkedEvents) Replay(ctx context.Context, eventID uint64) error {
+	return p.locker.ExecuteWithLock(ctx, handlerLockName(p.outboxName, p.transportName), p.lockTimeout, func() (err error) {
+		conn, err := p.pool.TransactionalConnection(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = liberr.Join(err, conn.Close())
+		}()
+
+		tx, err := conn.BeginTransaction(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				err = liberr.Join(err, tx.Rollback())
+			}
+		}()
+
+		event, err := p.storage.ParkedEvent(ctx, tx, p.transportName, eventID)
+		if err != nil {
+			return err
+		}
+
+		err = p.transport.HandleEvents(ctx, event.Message)
+		if err != nil {
+			return err
+		}
+
+		err = p.storage.DeleteParkedEvent(ctx, tx, p.transportName, eventID)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+func (p parkedEvents) Discard(ctx context.Context, eventID uint64) error {
+	return p.locker.ExecuteWithLock(ctx, handlerLockName(p.outboxName, p.transportName), p.lockTimeout, func() (err error) {
+		conn, err := p.pool.TransactionalConnection(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = liberr.Join(err, conn.Close())
+		}()
+
+		return p.storage.DeleteParkedEvent(ctx, conn, p.transportName, eventID)
+	})
+}
+
+type storedParkedEvent struct {
+	storedEvent
+	Attempts  uint         `db:"attempts"`
+	LastError string       `db:"last_error"`
+	ParkedAt  sqltime.Time `db:"parked_at"`
+}
+
+func (e storedParkedEvent) parkedEvent() (ParkedEvent, error) {
+	message, err := e.message()
+	if err != nil {
+		return ParkedEvent{}, err
+	}
+	return ParkedEvent{
+		Message:   message,
+		Attempts:  e.Attempts,
+		LastError: e.LastError,
+		ParkedAt:  e.ParkedAt.Time,
+	}, nil
+}
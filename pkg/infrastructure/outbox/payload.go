@@ -0,0 +1,56 @@
+package outbox
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const GzipPayloadEncoding = "gzip"
+
+var (
+	ErrPayloadTooLarge        = errors.New("event payload is too large")
+	ErrUnknownPayloadEncoding = errors.New("unknown payload encoding")
+)
+
+func encodePayload(payload string, compressionThreshold *uint) (string, string, error) {
+	if compressionThreshold == nil || uint(len(payload)) < *compressionThreshold {
+		return payload, "", nil
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(payload))
+	if err != nil {
+		return "", "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", "", err
+	}
+	if buf.Len() >= len(payload) {
+		return payload, "", nil
+	}
+	return buf.String(), GzipPayloadEncoding, nil
+}
+
+func decodePayload(payload []byte, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return string(payload), nil
+	case GzipPayloadEncoding:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return "", err
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		return string(data), r.Close()
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownPayloadEncoding, encoding)
+	}
+}
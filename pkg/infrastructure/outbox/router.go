@@ -0,0 +1,32 @@
+package outbox
+
+import (
+	"context"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/outbox"
+)
+
+func NewEventRouter[E outbox.Event](deserializer outbox.EventDeserializer[E], handler outbox.EventHandler[E]) Transport {
+	return &eventRouter[E]{
+		deserializer: deserializer,
+		handler:      handler,
+	}
+}
+
+type eventRouter[E outbox.Event] struct {
+	deserializer outbox.EventDeserializer[E]
+	handler      outbox.EventHandler[E]
+}
+
+func (r *eventRouter[E]) HandleEvents(ctx context.Context, message Message) error {
+	event, err := r.deserializer.Deserialize(message.EventType, message.Payload)
+	if err != nil {
+		return err
+	}
+
+	ctx = outbox.WithMetadata(ctx, outbox.Metadata{
+		Headers:       message.Headers,
+		SchemaVersion: message.SchemaVersion,
+	})
+	return r.handler(ctx, event)
+}
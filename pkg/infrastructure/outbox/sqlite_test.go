@@ -0,0 +1,340 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	// include sqlite driver
+	_ "modernc.org/sqlite"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/claimcheck"
+	"gitea.xscloud.ru/xscloud/golib/pkg/application/outbox"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/amqp"
+	infraclaimcheck "gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/claimcheck"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/logging"
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	outboxmigrations "gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/outbox/migrations"
+)
+
+const testTransportName = "test"
+
+type testEvent struct {
+	EventType string `json:"type"`
+	Value     string `json:"value"`
+}
+
+func (e testEvent) Type() string {
+	return e.EventType
+}
+
+type recordingTransport struct {
+	messages []Message
+	fail     func(message Message) error
+}
+
+func (t *recordingTransport) HandleEvents(_ context.Context, message Message) error {
+	if t.fail != nil {
+		if err := t.fail(message); err != nil {
+			return err
+		}
+	}
+	t.messages = append(t.messages, message)
+	return nil
+}
+
+func (t *recordingTransport) eventTypes() []string {
+	eventTypes := make([]string, 0, len(t.messages))
+	for _, message := range t.messages {
+		eventTypes = append(eventTypes, message.EventType)
+	}
+	return eventTypes
+}
+
+type sqliteOutbox struct {
+	db         *sqlx.DB
+	pool       mysql.ConnectionPool
+	uow        mysql.UnitOfWork
+	locker     Locker
+	dispatcher EventDispatcher[testEvent]
+	transport  *recordingTransport
+}
+
+func newSQLiteOutbox(t *testing.T, opts ...DispatcherOption) *sqliteOutbox {
+	db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db")+"?_pragma=busy_timeout(5000)")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	require.NoError(t, outboxmigrations.CreateSQLiteSchema(t.Context(), db, testTransportName))
+
+	pool := mysql.NewConnectionPool(mysql.NewTransactionalClientFromSQLx(db))
+	uow := mysql.NewUnitOfWork(pool, func(client mysql.ClientContext) mysql.ClientContext {
+		return client
+	})
+	dispatcher, err := NewEventDispatcher[testEvent](
+		"app",
+		testTransportName,
+		outbox.NewJSONEventSerializer[testEvent](),
+		uow,
+		append([]DispatcherOption{WithStorage(NewSQLiteStorage(testTransportName))}, opts...)...,
+	)
+	require.NoError(t, err)
+	return &sqliteOutbox{
+		db:         db,
+		pool:       pool,
+		uow:        uow,
+		locker:     NewLocalLocker(),
+		dispatcher: dispatcher,
+		transport:  &recordingTransport{},
+	}
+}
+
+func (o *sqliteOutbox) dispatch(t *testing.T, ctx context.Context, eventTypes ...string) {
+	for _, eventType := range eventTypes {
+		require.NoError(t, o.dispatcher.Dispatch(ctx, testEvent{EventType: eventType, Value: eventType}))
+	}
+}
+
+func (o *sqliteOutbox) handler(t *testing.T, maxAttempts uint) *handler {
+	h, err := NewEventHandler(EventHandlerConfig{
+		TransportName:  testTransportName,
+		Transport:      o.transport,
+		ConnectionPool: o.pool,
+		Storage:        NewSQLiteStorage(testTransportName),
+		Locker:         o.locker,
+		Logger:         logging.NewJSONLogger(&logging.Config{AppName: "test"}),
+		MaxAttempts:    &maxAttempts,
+	})
+	require.NoError(t, err)
+	return h.(*handler)
+}
+
+func (o *sqliteOutbox) consumer(t *testing.T, transportName string, transport Transport) *handler {
+	h, err := NewEventHandler(EventHandlerConfig{
+		OutboxName:     testTransportName,
+		TransportName:  transportName,
+		Transport:      transport,
+		ConnectionPool: o.pool,
+		Storage:        NewSQLiteStorage(testTransportName),
+		Locker:         o.locker,
+		Logger:         logging.NewJSONLogger(&logging.Config{AppName: "test"}),
+	})
+	require.NoError(t, err)
+	return h.(*handler)
+}
+
+func (o *sqliteOutbox) administrator(t *testing.T) Administrator {
+	admin, err := NewAdministrator(AdministratorConfig{
+		TransportName:  testTransportName,
+		Transport:      o.transport,
+		ConnectionPool: o.pool,
+		Storage:        NewSQLiteStorage(testTransportName),
+		Locker:         o.locker,
+	})
+	require.NoError(t, err)
+	return admin
+}
+
+func (o *sqliteOutbox) sendEvents(t *testing.T, h *handler) {
+	_, err := h.sendEvents(t.Context(), make(chan bool, 1))
+	require.NoError(t, err)
+}
+
+func TestSQLiteOutbox(t *testing.T) {
+	t.Run("relays dispatched events with metadata", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		ctx := outbox.WithSchemaVersion(outbox.WithHeader(t.Context(), "traceparent", "trace"), 2)
+		o.dispatch(t, ctx, "first", "second", "third")
+
+		o.sendEvents(t, o.handler(t, 0))
+
+		assert.Equal(t, []string{"first", "second", "third"}, o.transport.eventTypes())
+		assert.Equal(t, map[string]string{"traceparent": "trace"}, o.transport.messages[0].Headers)
+		assert.Equal(t, uint(2), o.transport.messages[0].SchemaVersion)
+		assert.False(t, o.transport.messages[0].CreatedAt.IsZero())
+
+		cursor, err := o.administrator(t).Cursor(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, o.transport.messages[2].EventID, cursor)
+	})
+
+	t.Run("parks poison event and replays it", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		o.dispatch(t, t.Context(), "first", "poison", "third")
+		poisoned := true
+		o.transport.fail = func(message Message) error {
+			if poisoned && message.EventType == "poison" {
+				return errors.New("poison event")
+			}
+			return nil
+		}
+		h := o.handler(t, 2)
+
+		o.sendEvents(t, h)
+		assert.Equal(t, []string{"first"}, o.transport.eventTypes())
+
+		o.sendEvents(t, h)
+		assert.Equal(t, []string{"first", "third"}, o.transport.eventTypes())
+
+		parkedEvents, err := NewParkedEvents(ParkedEventsConfig{
+			TransportName:  testTransportName,
+			Transport:      o.transport,
+			ConnectionPool: o.pool,
+			Storage:        NewSQLiteStorage(testTransportName),
+			Locker:         o.locker,
+		})
+		require.NoError(t, err)
+		parked, err := parkedEvents.List(t.Context(), 0, 10)
+		require.NoError(t, err)
+		require.Len(t, parked, 1)
+		assert.Equal(t, "poison", parked[0].EventType)
+		assert.Equal(t, uint(2), parked[0].Attempts)
+		assert.Equal(t, "poison event", parked[0].LastError)
+
+		poisoned = false
+		require.NoError(t, parkedEvents.Replay(t.Context(), parked[0].EventID))
+		assert.Equal(t, []string{"first", "third", "poison"}, o.transport.eventTypes())
+
+		parked, err = parkedEvents.List(t.Context(), 0, 10)
+		require.NoError(t, err)
+		assert.Empty(t, parked)
+		assert.ErrorIs(t, parkedEvents.Discard(t.Context(), o.transport.messages[2].EventID), ErrParkedEventNotFound)
+	})
+
+	t.Run("resets cursor and replays range", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		o.dispatch(t, t.Context(), "first", "second", "third")
+		o.sendEvents(t, o.handler(t, 0))
+		admin := o.administrator(t)
+		first := o.transport.messages[0].EventID
+		third := o.transport.messages[2].EventID
+
+		require.NoError(t, admin.Replay(t.Context(), first, first+1))
+		assert.Equal(t, []string{"first", "second", "third", "first", "second"}, o.transport.eventTypes())
+
+		require.NoError(t, admin.ResetCursor(t.Context(), first))
+		backlog, err := admin.Backlog(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, third, backlog.LastEventID)
+		assert.Equal(t, uint64(2), backlog.Size)
+	})
+
+	t.Run("relays shared outbox to several transports", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		o.dispatch(t, t.Context(), "first", "second")
+		amqp := &recordingTransport{}
+		audit := &recordingTransport{}
+		amqpHandler := o.consumer(t, "amqp", amqp)
+		auditHandler := o.consumer(t, "audit", audit)
+
+		o.sendEvents(t, amqpHandler)
+		o.dispatch(t, t.Context(), "third")
+		o.sendEvents(t, auditHandler)
+		o.sendEvents(t, amqpHandler)
+
+		assert.Equal(t, []string{"first", "second", "third"}, amqp.eventTypes())
+		assert.Equal(t, []string{"first", "second", "third"}, audit.eventTypes())
+		assert.Empty(t, o.transport.messages)
+	})
+
+	t.Run("rejects invalid names", func(t *testing.T) {
+		for _, name := range []string{"", "audit-log", "audit`; DROP TABLE users; --", strings.Repeat("a", 44)} {
+			_, err := NewEventDispatcher[testEvent]("app", name, outbox.NewJSONEventSerializer[testEvent](), nil)
+			assert.ErrorIs(t, err, ErrInvalidName, name)
+		}
+
+		_, err := NewEventHandler(EventHandlerConfig{OutboxName: testTransportName, TransportName: "audit log"})
+		assert.ErrorIs(t, err, ErrInvalidName)
+
+		_, err = NewEventDispatcher[testEvent]("app", strings.Repeat("a", 43), outbox.NewJSONEventSerializer[testEvent](), nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("routes relayed events to typed handler", func(t *testing.T) {
+		o := newSQLiteOutbox(t)
+		ctx := outbox.WithHeader(t.Context(), "traceparent", "trace")
+		o.dispatch(t, ctx, "known", "unknown")
+
+		registry := outbox.NewEventRegistry[testEvent]()
+		registry.Register("known", outbox.JSONEventDecoder[testEvent, testEvent]())
+		var handled []testEvent
+		var headers []map[string]string
+		router := NewEventRouter[testEvent](registry, func(ctx context.Context, event testEvent) error {
+			handled = append(handled, event)
+			headers = append(headers, outbox.MetadataFromContext(ctx).Headers)
+			return nil
+		})
+
+		o.sendEvents(t, o.handler(t, 0))
+		require.Len(t, o.transport.messages, 2)
+
+		require.NoError(t, router.HandleEvents(t.Context(), o.transport.messages[0]))
+		assert.Equal(t, []testEvent{{EventType: "known", Value: "known"}}, handled)
+		assert.Equal(t, []map[string]string{{"traceparent": "trace"}}, headers)
+		assert.ErrorIs(t, router.HandleEvents(t.Context(), o.transport.messages[1]), outbox.ErrUnknownEventType)
+	})
+
+	t.Run("compresses large payloads and limits their size", func(t *testing.T) {
+		o := newSQLiteOutbox(t, WithCompression(64), WithMaxPayloadSize(256))
+		large := strings.Repeat("compressible ", 100)
+		require.NoError(t, o.dispatcher.Dispatch(t.Context(), testEvent{EventType: "large", Value: large}))
+		o.dispatch(t, t.Context(), "small")
+
+		var encodings []string
+		require.NoError(t, o.db.Select(&encodings, `SELECT payload_encoding FROM outbox_test_event ORDER BY event_id`))
+		assert.Equal(t, []string{GzipPayloadEncoding, ""}, encodings)
+
+		o.sendEvents(t, o.handler(t, 0))
+		require.Len(t, o.transport.messages, 2)
+		assert.JSONEq(t, `{"type":"large","value":"`+large+`"}`, o.transport.messages[0].Payload)
+		assert.JSONEq(t, `{"type":"small","value":"small"}`, o.transport.messages[1].Payload)
+
+		random := make([]byte, 512)
+		_, _ = rand.Read(random)
+		err := o.dispatcher.Dispatch(t.Context(), testEvent{EventType: "random", Value: hex.EncodeToString(random)})
+		assert.ErrorIs(t, err, ErrPayloadTooLarge)
+	})
+
+	t.Run("offloads oversized payloads to claim-check store", func(t *testing.T) {
+		store := infraclaimcheck.NewFileSystemBlobStore(t.TempDir())
+		o := newSQLiteOutbox(t, WithClaimCheck(store, 64))
+		large := strings.Repeat("document ", 100)
+		require.NoError(t, o.dispatcher.Dispatch(t.Context(), testEvent{EventType: "large", Value: large}))
+
+		o.sendEvents(t, o.handler(t, 0))
+		require.Len(t, o.transport.messages, 1)
+		message := o.transport.messages[0]
+		assert.Empty(t, message.Payload)
+		require.Contains(t, message.Headers, claimcheck.ReferenceHeader)
+
+		var received amqp.Delivery
+		resolver := amqp.NewClaimCheckResolver(store, func(_ context.Context, delivery amqp.Delivery) error {
+			received = delivery
+			return nil
+		})
+		require.NoError(t, resolver(t.Context(), amqp.Delivery{
+			Type:    message.EventType,
+			Headers: message.Headers,
+			Body:    []byte(message.Payload),
+		}))
+		assert.JSONEq(t, `{"type":"large","value":"`+large+`"}`, string(received.Body))
+		assert.NotContains(t, received.Headers, claimcheck.ReferenceHeader)
+
+		_, err := store.Get(t.Context(), message.Headers[claimcheck.ReferenceHeader])
+		require.NoError(t, err)
+		resolver = amqp.NewClaimCheckResolver(store, func(context.Context, amqp.Delivery) error {
+			return nil
+		}, amqp.WithBlobDeletion())
+		require.NoError(t, resolver(t.Context(), amqp.Delivery{Headers: message.Headers}))
+		_, err = store.Get(t.Context(), message.Headers[claimcheck.ReferenceHeader])
+		assert.ErrorIs(t, err, claimcheck.ErrBlobNotFound)
+	})
+}
@@ -0,0 +1,324 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"gitea.xscloud.ru/xscloud/golib/pkg/infrastructure/mysql"
+	"gitea.xscloud.ru/xscloud/golib/pkg/internal/sqltime"
+)
+
+type EventStorage interface {
+	Append(ctx context.Context, client mysql.ClientContext, message Message) error
+	Events(ctx context.Context, client mysql.ClientContext, afterEventID uint64, limit uint) ([]Message, error)
+	EventsRange(ctx context.Context, client mysql.ClientContext, afterEventID, toEventID uint64, limit uint) ([]Message, error)
+	LastEventID(ctx context.Context, client mysql.ClientContext) (uint64, error)
+	LastEventIDBefore(ctx context.Context, client mysql.ClientContext, createdAt time.Time) (uint64, error)
+	OldestEventCreatedAt(ctx context.Context, client mysql.ClientContext, afterEventID uint64) (time.Time, bool, error)
+	Sequence(ctx context.Context, client mysql.ClientContext) (Sequence, error)
+}
+
+type CursorStorage interface {
+	Cursor(ctx context.Context, client mysql.ClientContext, transportName string) (Cursor, error)
+	TrackEvent(ctx context.Context, client mysql.ClientContext, transportName string, eventID uint64) error
+	TrackFailedEvent(ctx context.Context, client mysql.ClientContext, transportName string, cursor Cursor) error
+}
+
+type ParkedEventStorage interface {
+	ParkEvent(ctx context.Context, client mysql.ClientContext, transportName string, event ParkedEvent) error
+	ParkedEvents(ctx context.Context, client mysql.ClientContext, transportName string, afterEventID uint64, limit uint) ([]ParkedEvent, error)
+	ParkedEvent(ctx context.Context, client mysql.ClientContext, transportName string, eventID uint64) (ParkedEvent, error)
+	DeleteParkedEvent(ctx context.Context, client mysql.ClientContext, transportName string, eventID uint64) error
+}
+
+type Storage interface {
+	EventStorage
+	CursorStorage
+	ParkedEventStorage
+}
+
+const (
+	eventTable        = "event"
+	trackedEventTable = "tracked_event"
+	parkedEventTable  = "parked_event"
+)
+
+type Cursor struct {
+	LastTrackedEventID uint64
+	FailedEventID      uint64
+	FailedAttempts     uint
+}
+
+func NewMySQLStorage(outboxName string) Storage {
+	return &sqlStorage{outboxName: outboxName, dialect: mysqlDialect}
+}
+
+func NewPostgreSQLStorage(outboxName string) Storage {
+	return &sqlStorage{outboxName: outboxName, dialect: postgresDialect}
+}
+
+func NewSQLiteStorage(outboxName string) Storage {
+	return &sqlStorage{outboxName: outboxName, dialect: sqliteDialect}
+}
+
+type sqlStorage struct {
+	outboxName string
+	dialect    dialect
+}
+
+func (s *sqlStorage) Append(ctx context.Context, client mysql.ClientContext, message Message) error {
+	_, err := client.ExecContext(ctx, s.query(eventTable, `
+		INSERT INTO %[1]s (correlation_id, event_type, payload, payload_encoding, headers, schema_version, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`),
+		message.CorrelationID,
+		message.EventType,
+		[]byte(message.Payload),
+		message.PayloadEncoding,
+		eventHeaders(message.Headers),
+		message.SchemaVersion,
+		message.CreatedAt,
+	)
+	return err
+}
+
+func (s *sqlStorage) Events(ctx context.Context, client mysql.ClientContext, afterEventID uint64, limit uint) ([]Message, error) {
+	var events []storedEvent
+	err := client.SelectContext(ctx, &events, s.query(eventTable, `
+		SELECT
+		    event_id,
+		    correlation_id,
+		    event_type,
+		    payload,
+		    payload_encoding,
+		    headers,
+		    schema_version,
+		    created_at
+		FROM %[1]s
+		WHERE event_id > ?
+		ORDER BY event_id
+		LIMIT %[2]v
+	`, limit), afterEventID)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages(events)
+}
+
+func (s *sqlStorage) EventsRange(ctx context.Context, client mysql.ClientContext, afterEventID, toEventID uint64, limit uint) ([]Message, error) {
+	var events []storedEvent
+	err := client.SelectContext(ctx, &events, s.query(eventTable, `
+		SELECT
+		    event_id,
+		    correlation_id,
+		    event_type,
+		    payload,
+		    payload_encoding,
+		    headers,
+		    schema_version,
+		    created_at
+		FROM %[1]s
+		WHERE event_id > ? AND event_id <= ?
+		ORDER BY event_id
+		LIMIT %[2]v
+	`, limit), afterEventID, toEventID)
+	if err != nil {
+		return nil, err
+	}
+
+	return messages(events)
+}
+
+func (s *sqlStorage) LastEventID(ctx context.Context, client mysql.ClientContext) (uint64, error) {
+	var lastEventID uint64
+	err := client.GetContext(ctx, &lastEventID, s.query(eventTable, `
+		SELECT COALESCE(MAX(event_id), 0) FROM %[1]s
+	`))
+	return lastEventID, err
+}
+
+func (s *sqlStorage) LastEventIDBefore(ctx context.Context, client mysql.ClientContext, createdAt time.Time) (uint64, error) {
+	var lastEventID uint64
+	err := client.GetContext(ctx, &lastEventID, s.query(eventTable, `
+		SELECT COALESCE(MAX(event_id), 0) FROM %[1]s WHERE created_at < ?
+	`), createdAt)
+	return lastEventID, err
+}
+
+func (s *sqlStorage) OldestEventCreatedAt(ctx context.Context, client mysql.ClientContext, afterEventID uint64) (time.Time, bool, error) {
+	var createdAt sqltime.Time
+	err := client.GetContext(ctx, &createdAt, s.query(eventTable, `
+		SELECT created_at FROM %[1]s WHERE event_id > ? ORDER BY event_id LIMIT 1
+	`), afterEventID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, err
+	}
+	return createdAt.Time, true, nil
+}
+
+func (s *sqlStorage) Sequence(ctx context.Context, client mysql.ClientContext) (Sequence, error) {
+	return s.dialect.sequence(ctx, client)
+}
+
+func (s *sqlStorage) Cursor(ctx context.Context, client mysql.ClientContext, transportName string) (Cursor, error) {
+	var tracked trackedEvent
+	err := client.GetContext(ctx, &tracked, s.query(trackedEventTable, `
+		SELECT
+		    last_tracked_event_id,
+		    failed_event_id,
+		    failed_attempts
+		FROM %[1]s
+		WHERE transport_name = ?
+	`), transportName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Cursor{}, nil
+		}
+		return Cursor{}, err
+	}
+	return tracked.cursor(), nil
+}
+
+func (s *sqlStorage) TrackEvent(ctx context.Context, client mysql.ClientContext, transportName string, eventID uint64) error {
+	_, err := client.ExecContext(ctx, s.query(trackedEventTable, s.dialect.upsert(`
+		INSERT INTO %[1]s (transport_name, last_tracked_event_id, failed_event_id, failed_attempts) VALUES (?, ?, NULL, 0)
+	`, []string{"transport_name"}, "last_tracked_event_id", "failed_event_id", "failed_attempts")), transportName, eventID)
+	return err
+}
+
+func (s *sqlStorage) TrackFailedEvent(ctx context.Context, client mysql.ClientContext, transportName string, cursor Cursor) error {
+	_, err := client.ExecContext(ctx, s.query(trackedEventTable, s.dialect.upsert(`
+		INSERT INTO %[1]s (transport_name, last_tracked_event_id, failed_event_id, failed_attempts) VALUES (?, ?, ?, ?)
+	`, []string{"transport_name"}, "failed_event_id", "failed_attempts")),
+		transportName,
+		cursor.LastTrackedEventID,
+		cursor.FailedEventID,
+		cursor.FailedAttempts,
+	)
+	return err
+}
+
+func (s *sqlStorage) ParkEvent(ctx context.Context, client mysql.ClientContext, transportName string, event ParkedEvent) error {
+	_, err := client.ExecContext(ctx, s.query(parkedEventTable, s.dialect.upsert(`
+		INSERT INTO %[1]s (
+		    transport_name,
+		    event_id,
+		    correlation_id,
+		    event_type,
+		    payload,
+		    payload_encoding,
+		    headers,
+		    schema_version,
+		    created_at,
+		    attempts,
+		    last_error,
+		    parked_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, []string{"transport_name", "event_id"}, "attempts", "last_error", "parked_at")),
+		transportName,
+		event.EventID,
+		event.CorrelationID,
+		event.EventType,
+		[]byte(event.Payload),
+		event.PayloadEncoding,
+		eventHeaders(event.Headers),
+		event.SchemaVersion,
+		event.CreatedAt,
+		event.Attempts,
+		event.LastError,
+		event.ParkedAt,
+	)
+	return err
+}
+
+func (s *sqlStorage) ParkedEvents(ctx context.Context, client mysql.ClientContext, transportName string, afterEventID uint64, limit uint) ([]ParkedEvent, error) {
+	var stored []storedParkedEvent
+	err := client.SelectContext(ctx, &stored, s.query(parkedEventTable, `
+		SELECT
+		    event_id,
+		    correlation_id,
+		    event_type,
+		    payload,
+		    payload_encoding,
+		    headers,
+		    schema_version,
+		    created_at,
+		    attempts,
+		    last_error,
+		    parked_at
+		FROM %[1]s
+		WHERE transport_name = ? AND event_id > ?
+		ORDER BY event_id
+		LIMIT %[2]v
+	`, limit), transportName, afterEventID)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]ParkedEvent, 0, len(stored))
+	for _, event := range stored {
+		parked, err := event.parkedEvent()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, parked)
+	}
+	return events, nil
+}
+
+func (s *sqlStorage) ParkedEvent(ctx context.Context, client mysql.ClientContext, transportName string, eventID uint64) (ParkedEvent, error) {
+	var event storedParkedEvent
+	err := client.GetContext(ctx, &event, s.query(parkedEventTable, `
+		SELECT
+		    event_id,
+		    correlation_id,
+		    event_type,
+		    payload,
+		    payload_encoding,
+		    headers,
+		    schema_version,
+		    created_at,
+		    attempts,
+		    last_error,
+		    parked_at
+		FROM %[1]s
+		WHERE transport_name = ? AND event_id = ?
+	`+s.dialect.forUpdate), transportName, eventID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ParkedEvent{}, ErrParkedEventNotFound
+		}
+		return ParkedEvent{}, err
+	}
+	return event.parkedEvent()
+}
+
+func (s *sqlStorage) DeleteParkedEvent(ctx context.Context, client mysql.ClientContext, transportName string, eventID uint64) error {
+	result, err := client.ExecContext(ctx, s.query(parkedEventTable, `
+		DELETE FROM %[1]s WHERE transport_name = ? AND event_id = ?
+	`), transportName, eventID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrParkedEventNotFound
+	}
+	return nil
+}
+
+func (s *sqlStorage) query(table, query string, args ...any) string {
+	tableName := s.dialect.quote(fmt.Sprintf("outbox_%s_%s", s.outboxName, table))
+	return sqlx.Rebind(s.dialect.bindType, fmt.Sprintf(query, append([]any{tableName}, args...)...))
+}
@@ -0,0 +1,32 @@
+package identifier
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const MaxLength = 64
+
+var ErrInvalid = errors.New("invalid identifier")
+
+var pattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+func Validate(name string, maxLength int) error {
+	if !pattern.MatchString(name) {
+		return fmt.Errorf("%w %q: only latin letters, digits and underscores are allowed", ErrInvalid, name)
+	}
+	if len(name) > maxLength {
+		return fmt.Errorf("%w %q: longer than %d characters", ErrInvalid, name, maxLength)
+	}
+	return nil
+}
+
+func QuoteMySQL(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func QuoteANSI(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,50 @@
+package sqltest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+)
+
+// RecordingClient is a mysql.ClientContext that records queries with whitespace collapsed and runs none of them
+type RecordingClient struct {
+	Queries []string
+	Execs   []string
+	// Get answers GetContext, a nil Get leaves dest untouched
+	Get func(dest interface{}, query string) error
+}
+
+func (c *RecordingClient) QueryContext(_ context.Context, query string, _ ...interface{}) (*sql.Rows, error) {
+	c.record(query)
+	return nil, sql.ErrNoRows
+}
+
+func (c *RecordingClient) QueryRowContext(_ context.Context, query string, _ ...interface{}) *sql.Row {
+	c.record(query)
+	return nil
+}
+
+func (c *RecordingClient) ExecContext(_ context.Context, query string, _ ...interface{}) (sql.Result, error) {
+	c.Execs = append(c.Execs, c.record(query))
+	return driver.RowsAffected(1), nil
+}
+
+func (c *RecordingClient) SelectContext(_ context.Context, _ interface{}, query string, _ ...interface{}) error {
+	c.record(query)
+	return nil
+}
+
+func (c *RecordingClient) GetContext(_ context.Context, dest interface{}, query string, _ ...interface{}) error {
+	query = c.record(query)
+	if c.Get == nil {
+		return nil
+	}
+	return c.Get(dest, query)
+}
+
+func (c *RecordingClient) record(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	c.Queries = append(c.Queries, query)
+	return query
+}
@@ -0,0 +1,48 @@
+package sqltime
+
+import (
+	"fmt"
+	"time"
+)
+
+var layouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	time.DateOnly,
+}
+
+// Time scans DATETIME and TIMESTAMP columns whether the driver returns time.Time or text,
+// MySQL returns text unless the DSN sets parseTime, text without an offset is read as UTC
+type Time struct {
+	time.Time
+}
+
+func (t *Time) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = src
+		return nil
+	case []byte:
+		return t.parse(string(src))
+	case string:
+		return t.parse(src)
+	default:
+		return fmt.Errorf("sqltime: cannot scan %T into time", src)
+	}
+}
+
+func (t *Time) parse(value string) error {
+	for _, layout := range layouts {
+		parsed, err := time.ParseInLocation(layout, value, time.UTC)
+		if err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("sqltime: cannot parse %q as time", value)
+}
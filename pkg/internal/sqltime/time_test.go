@@ -0,0 +1,41 @@
+package sqltime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeScan(t *testing.T) {
+	expected := time.Date(2024, time.March, 5, 10, 20, 30, 0, time.UTC)
+
+	for name, src := range map[string]any{
+		"time":           expected,
+		"mysql text":     []byte("2024-03-05 10:20:30"),
+		"mysql fraction": []byte("2024-03-05 10:20:30.000000"),
+		"sqlite text":    "2024-03-05 10:20:30+00:00",
+		"go time string": "2024-03-05 10:20:30 +0000 UTC",
+		"rfc3339":        "2024-03-05T10:20:30Z",
+		"offset rfc3339": "2024-03-05T13:20:30+03:00",
+	} {
+		t.Run(name, func(t *testing.T) {
+			var scanned Time
+			require.NoError(t, scanned.Scan(src))
+			assert.True(t, expected.Equal(scanned.Time), scanned.Time)
+		})
+	}
+
+	t.Run("null", func(t *testing.T) {
+		scanned := Time{Time: expected}
+		require.NoError(t, scanned.Scan(nil))
+		assert.True(t, scanned.IsZero())
+	})
+
+	t.Run("rejects unknown value", func(t *testing.T) {
+		var scanned Time
+		assert.Error(t, scanned.Scan("yesterday"))
+		assert.Error(t, scanned.Scan(int64(1)))
+	})
+}